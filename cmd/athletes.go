@@ -0,0 +1,255 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var athletesGoalYear int
+var athletesDeleteConfirmed bool
+
+// listAthletes prints all athletes with their progress in the year of `now`
+func listAthletes(out io.Writer, now time.Time) error {
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		return err
+	}
+	sort.Ints(athleteIDs)
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tGOAL KM\tDISTANCE KM\tACTIVITIES")
+	for _, athleteID := range athleteIDs {
+		goal, err := GetGoal(athleteID, now.Year())
+		if err != nil {
+			return err
+		}
+		goalGear, err := GetGoalGear(athleteID, now.Year())
+		if err != nil {
+			return err
+		}
+		activities, err := GetActivities(athleteID)
+		if err != nil {
+			return err
+		}
+		distance := 0.0
+		rides := 0
+		for _, activity := range activities {
+			if activity.StartDateLocal.Year() != now.Year() {
+				continue
+			}
+			rides++
+			if matchesGear(activity, goalGear) {
+				distance += activity.Distance
+			}
+		}
+		fmt.Fprintf(w, "%d\t%s\t%.0f\t%.2f\t%d\n", athleteID, GetAthleteName(athleteID), goal/1000, distance/1000, rides)
+	}
+	return w.Flush()
+}
+
+// showAthlete prints settings, goals and recent description edits of the
+// athlete
+func showAthlete(out io.Writer, athleteID int) error {
+	goals, err := GetGoals(athleteID)
+	if err != nil {
+		return err
+	}
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return err
+	}
+	annotated, err := GetAnnotatedActivities(athleteID)
+	if err != nil {
+		return err
+	}
+	outbox, err := GetOutbox(athleteID)
+	if err != nil {
+		return err
+	}
+	description, err := GetDescriptionSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	email, err := GetEmailSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	push, err := GetPushSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	webhooks, err := GetWebhooks(athleteID)
+	if err != nil {
+		return err
+	}
+	tokens, err := GetAthleteAPITokens(athleteID)
+	if err != nil {
+		return err
+	}
+	audit, err := GetAuditLog(athleteID, AuditLogSize)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID:\t%d\n", athleteID)
+	fmt.Fprintf(w, "Name:\t%s\n", GetAthleteName(athleteID))
+	years := []int{}
+	for year := range goals {
+		years = append(years, year)
+	}
+	sort.Ints(years)
+	for _, year := range years {
+		fmt.Fprintf(w, "Goal %d:\t%.0f km\n", year, goals[year]/1000)
+	}
+	fmt.Fprintf(w, "Activities:\t%d stored, %d annotated, %d in the outbox\n", len(activities), len(annotated), len(outbox))
+	fmt.Fprintf(w, "Description:\t%+v\n", *description)
+	fmt.Fprintf(w, "Email:\t%s\n", email.Address)
+	fmt.Fprintf(w, "Push:\t%s %s\n", push.Service, push.URL)
+	fmt.Fprintf(w, "Webhooks:\t%d\n", len(webhooks))
+	fmt.Fprintf(w, "API tokens:\t%d\n", len(tokens))
+	err = w.Flush()
+	if err != nil {
+		return err
+	}
+
+	if len(audit) == 0 {
+		return nil
+	}
+	fmt.Fprintln(out, "\nRecent description edits:")
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, entry := range audit {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", entry.CreatedAt.Format("2006-01-02 15:04"), entry.ActivityID, entry.Action, entry.StatusCode, entry.Error)
+	}
+	return w.Flush()
+}
+
+// openDBCmd opens the database before subcommands which work with it
+func openDBCmd(cmd *cobra.Command, args []string) error {
+	return OpenDB(rootDBFilename)
+}
+
+// closeDBCmd closes the database opened by openDBCmd
+func closeDBCmd(cmd *cobra.Command, args []string) error {
+	return DB.Close()
+}
+
+// athletesCmd represents the athletes command
+var athletesCmd = &cobra.Command{
+	Use:   "athletes",
+	Short: "Inspect and manage athletes",
+	Long: `Works with the database file directly, so the application has to be stopped
+first. Otherwise the command fails because the file is locked.`,
+	PersistentPreRunE:  openDBCmd,
+	PersistentPostRunE: closeDBCmd,
+}
+
+var athletesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all athletes with their progress this year",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return listAthletes(cmd.OutOrStdout(), time.Now())
+	},
+}
+
+var athletesShowCmd = &cobra.Command{
+	Use:   "show ID",
+	Short: "Show settings and recent description edits of the athlete",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		athleteID, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		return showAthlete(cmd.OutOrStdout(), athleteID)
+	},
+}
+
+var athletesSetGoalCmd = &cobra.Command{
+	Use:   "set-goal ID KM",
+	Short: "Set the goal of the athlete",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		athleteID, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		goal, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return err
+		}
+		if goal <= 0 {
+			return fmt.Errorf("goal must be positive")
+		}
+		err = SetGoal(athleteID, athletesGoalYear, goal)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "goal of athlete %d for %d is %.0f km\n", athleteID, athletesGoalYear, goal)
+		return nil
+	},
+}
+
+var athletesDeleteCmd = &cobra.Command{
+	Use:   "delete ID",
+	Short: "Delete the athlete with all data",
+	Long: `Deletes the athlete with all activities, summaries, audit log and API tokens.
+Activity descriptions on Strava are not changed, use the undo command first to
+restore them.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		athleteID, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		if !athletesDeleteConfirmed {
+			return fmt.Errorf("deleting athlete %d can't be undone, add --yes to confirm", athleteID)
+		}
+		err = DeleteAthlete(athleteID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "deleted athlete %d\n", athleteID)
+		return nil
+	},
+}
+
+var athletesReprocessCmd = &cobra.Command{
+	Use:   "reprocess ID ACTIVITY",
+	Short: "Render the description of the activity again",
+	Long: `Renders the progress block of the activity again with the current settings,
+even if the activity already has the block or its annotation was undone. Webhooks
+and notifications are not sent again.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		athleteID, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		activityID, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		err = processActivity(activityID, athleteID, true)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "reprocessed activity %d\n", activityID)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(athletesCmd)
+	athletesCmd.AddCommand(athletesListCmd, athletesShowCmd, athletesSetGoalCmd, athletesDeleteCmd, athletesReprocessCmd)
+
+	athletesSetGoalCmd.Flags().IntVar(&athletesGoalYear, "year", time.Now().Year(), "year of the goal")
+	athletesDeleteCmd.Flags().BoolVar(&athletesDeleteConfirmed, "yes", false, "confirm the deletion")
+}
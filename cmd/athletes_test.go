@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_listAthletes(t *testing.T) {
+	setupTestDB(t)
+	now := time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)
+	err := SetAthleteName(1, "Jane")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetGoal(1, 2023, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveActivities(1, []Activity{
+		{ID: 10, Distance: 12500, StartDateLocal: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 11, Distance: 40000, StartDateLocal: time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = listAthletes(&out, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || strings.Join(strings.Fields(lines[1]), " ") != "1 Jane 3000 12.50 1" {
+		t.Errorf("unexpected output:\n%s", out.String())
+	}
+}
+
+func Test_showAthlete(t *testing.T) {
+	setupTestDB(t)
+	err := SetGoal(1, 2023, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddAuditEntry(1, &AuditEntry{ActivityID: 10, Action: AuditAnnotate, StatusCode: 200})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = showAthlete(&out, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"3000 km", "Recent description edits", "10  annotate  200"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("expected %q in output:\n%s", expected, out.String())
+		}
+	}
+
+	err = showAthlete(&out, 2)
+	if err == nil {
+		t.Errorf("expected an error for the unknown athlete")
+	}
+}
+
+func Test_DeleteAthlete(t *testing.T) {
+	setupTestDB(t)
+	err := SaveAuthData(2, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveActivities(1, []Activity{{ID: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddAuditEntry(1, &AuditEntry{ActivityID: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveAPIToken(&APIToken{Hash: "h1", AthleteID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveAPIToken(&APIToken{Hash: "h2", AthleteID: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, team := range []*Team{{ID: "solo", Members: []int{1}}, {ID: "pair", Members: []int{1, 2}}} {
+		err = SaveTeam(team)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = SaveChallenge(&Challenge{ID: "c", Participants: []int{2, 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = DeleteAthlete(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	athleteIDs, _ := GetAthleteIDs()
+	if len(athleteIDs) != 1 || athleteIDs[0] != 2 {
+		t.Errorf("expected only athlete 2 to remain, got %v", athleteIDs)
+	}
+	activities, _ := GetActivities(1)
+	audit, _ := GetAuditLog(1, 0)
+	if len(activities) != 0 || len(audit) != 0 {
+		t.Errorf("expected activities and audit log to be deleted")
+	}
+	tokens, _ := GetAthleteAPITokens(2)
+	if _, err := GetAPIToken("h1"); err == nil || len(tokens) != 1 {
+		t.Errorf("expected only tokens of athlete 1 to be deleted")
+	}
+	if _, err := GetTeam("solo"); err == nil {
+		t.Errorf("expected the team without members to be deleted")
+	}
+	team, _ := GetTeam("pair")
+	challenge, _ := GetChallenge("c")
+	if len(team.Members) != 1 || team.Members[0] != 2 || len(challenge.Participants) != 1 || challenge.Participants[0] != 2 {
+		t.Errorf("expected athlete 1 to leave the team and the challenge, got %v %v", team.Members, challenge.Participants)
+	}
+
+	err = DeleteAthlete(1)
+	if err == nil {
+		t.Errorf("expected an error for the deleted athlete")
+	}
+}
@@ -0,0 +1,200 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var backfillAthleteID int
+var backfillSince string
+var backfillDryRun bool
+
+// BackfillEntry is the description the activity would have had when it was
+// uploaded
+type BackfillEntry struct {
+	Activity    Activity
+	Description string
+}
+
+// planBackfill renders descriptions of the activities which started on or
+// after `since` with cumulative totals as of each activity
+// Notes:
+//   - `activities` must include all activities since the beginning of the
+//     year of `since`, otherwise totals are too low
+//   - `goals` and `goalGear` are keyed by year. Years without a goal use the
+//     default goal of 5000 km
+//   - activities which already have the signature are skipped
+func planBackfill(activities []Activity, goals map[int]float64, goalGear map[int][]string, since time.Time) ([]BackfillEntry, error) {
+	sorted := make([]Activity, len(activities))
+	copy(sorted, activities)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].StartDate.Before(sorted[j].StartDate)
+	})
+
+	entries := []BackfillEntry{}
+	year := 0
+	totalDistance := 0.0
+	for _, activity := range sorted {
+		if activity.StartDateLocal.Year() != year {
+			year = activity.StartDateLocal.Year()
+			totalDistance = 0
+		}
+		contributedDistance := 0.0
+		if matchesGear(activity, goalGear[year]) {
+			contributedDistance = activity.Distance
+			totalDistance += activity.Distance
+		}
+		if localDay(activity.StartDateLocal).Before(since) || strings.Contains(activity.Description, Signature) {
+			continue
+		}
+
+		goal := goals[year]
+		if goal == 0 {
+			goal = 5000000
+		}
+		description, err := renderDescriptionAt(activity.StartDateLocal, goal, totalDistance, contributedDistance, activity.Description, Signature, nil)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, BackfillEntry{Activity: activity, Description: description})
+	}
+	return entries, nil
+}
+
+// backfillAthlete annotates past activities of the athlete. With `dryRun`
+// descriptions are only printed and nothing is stored. In the preview mode
+// descriptions are stored in the outbox instead of updating activities
+func backfillAthlete(athleteID int, since time.Time, dryRun bool, out io.Writer) error {
+	accessToken, err := RefreshAccessToken(athleteID)
+	if err != nil {
+		return err
+	}
+	startOfYear := time.Date(since.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	activities, err := getActivitiesBetween(accessToken, startOfYear, time.Time{})
+	if err != nil {
+		return err
+	}
+	if !dryRun {
+		// Past years are not stored, partially stored years would skew all-time
+		// and bike totals
+		currentYear := []Activity{}
+		for _, activity := range *activities {
+			if activity.StartDateLocal.Year() == time.Now().Year() {
+				currentYear = append(currentYear, activity)
+			}
+		}
+		err = SaveActivities(athleteID, currentYear)
+		if err != nil {
+			return err
+		}
+	}
+
+	goals := map[int]float64{}
+	goalGear := map[int][]string{}
+	for year := since.Year(); year <= time.Now().Year(); year++ {
+		goal, err := GetGoal(athleteID, year)
+		if err == nil {
+			goals[year] = goal
+		}
+		goalGear[year], err = GetGoalGear(athleteID, year)
+		if err != nil {
+			return err
+		}
+	}
+
+	entries, err := planBackfill(*activities, goals, goalGear, since)
+	if err != nil {
+		return err
+	}
+
+	settings, err := GetDescriptionSettings(athleteID)
+	if err != nil {
+		return err
+	}
+
+	updated := 0
+	for _, entry := range entries {
+		annotated, err := IsActivityAnnotated(athleteID, entry.Activity.ID)
+		if err != nil {
+			return err
+		}
+		reverted, err := IsActivityReverted(athleteID, entry.Activity.ID)
+		if err != nil {
+			return err
+		}
+		if annotated || reverted {
+			continue
+		}
+		if dryRun {
+			fmt.Fprintf(out, "%d %s %s\n%s\n\n", entry.Activity.ID, entry.Activity.StartDateLocal.Format("2006-01-02"), entry.Activity.Name, entry.Description)
+			continue
+		}
+		if settings.Preview {
+			err = SetOutboxEntry(athleteID, &OutboxEntry{
+				ActivityID:  entry.Activity.ID,
+				Name:        entry.Activity.Name,
+				StartDate:   entry.Activity.StartDate,
+				Original:    entry.Activity.Description,
+				Description: entry.Description,
+				CreatedAt:   time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+			updated++
+			fmt.Fprintf(out, "added to the outbox %d %s %s\n", entry.Activity.ID, entry.Activity.StartDateLocal.Format("2006-01-02"), entry.Activity.Name)
+			continue
+		}
+		err = annotateActivity(athleteID, accessToken, entry.Activity.ID, entry.Activity.Description, entry.Description)
+		if err != nil {
+			return fmt.Errorf("updated %d activities, stopped: %s", updated, err)
+		}
+		updated++
+		fmt.Fprintf(out, "updated %d %s %s\n", entry.Activity.ID, entry.Activity.StartDateLocal.Format("2006-01-02"), entry.Activity.Name)
+	}
+	switch {
+	case dryRun:
+	case settings.Preview:
+		fmt.Fprintf(out, "added %d activities to the outbox\n", updated)
+	default:
+		fmt.Fprintf(out, "updated %d activities\n", updated)
+	}
+	return nil
+}
+
+// backfillCmd represents the backfill command
+var backfillCmd = &cobra.Command{
+	Use:   "backfill",
+	Short: "Annotate past activities of the athlete",
+	Long: `Adds the progress block to past activities of the athlete. Every activity gets
+totals as of the time it was uploaded. Activities which were already annotated
+are skipped. The command is stopped when Strava rejects an update, e.g. because
+of the rate limit, and can be safely started again later.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		since, err := time.Parse("2006-01-02", backfillSince)
+		if err != nil {
+			return fmt.Errorf("invalid --since date, expected YYYY-MM-DD: %s", err)
+		}
+		err = OpenDB(rootDBFilename)
+		if err != nil {
+			return err
+		}
+		defer DB.Close()
+		return backfillAthlete(backfillAthleteID, since, backfillDryRun, cmd.OutOrStdout())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(backfillCmd)
+
+	backfillCmd.Flags().IntVar(&backfillAthleteID, "athlete", 0, "Strava athlete ID")
+	backfillCmd.Flags().StringVar(&backfillSince, "since", "", "annotate activities since the date, YYYY-MM-DD")
+	backfillCmd.Flags().BoolVar(&backfillDryRun, "dry-run", false, "print descriptions instead of updating activities")
+	backfillCmd.MarkFlagRequired("athlete")
+	backfillCmd.MarkFlagRequired("since")
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_planBackfill(t *testing.T) {
+	day := func(year int, month time.Month, d int) time.Time {
+		return time.Date(year, month, d, 10, 0, 0, 0, time.UTC)
+	}
+	activities := []Activity{
+		{ID: 5, Distance: 5000, StartDate: day(2026, 1, 2), StartDateLocal: day(2026, 1, 2), GearID: "b1"},
+		{ID: 1, Distance: 10000, StartDate: day(2025, 3, 1), StartDateLocal: day(2025, 3, 1), GearID: "b1"},
+		{ID: 2, Distance: 20000, StartDate: day(2025, 3, 10), StartDateLocal: day(2025, 3, 10), GearID: "b2"},
+		{ID: 3, Distance: 30000, StartDate: day(2025, 3, 15), StartDateLocal: day(2025, 3, 15), GearID: "b1", Description: "Morning ride"},
+		{ID: 4, Distance: 5000, StartDate: day(2025, 3, 20), StartDateLocal: day(2025, 3, 20), GearID: "b1", Description: "done\n" + Signature},
+	}
+	goals := map[int]float64{2025: 1000000}
+	goalGear := map[int][]string{2025: {"b1"}}
+
+	entries, err := planBackfill(activities, goals, goalGear, time.Date(2025, time.March, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		id       int
+		contains []string
+	}{
+		// The activity before `since` is counted but not annotated
+		{2, []string{"+0.00% towards the goal!", "10.00 of 1000.00 km (1.00%) in 2025"}},
+		{3, []string{"Morning ride", "+3.00% towards the goal!", "40.00 of 1000.00 km (4.00%) in 2025", "960.00 km and 290 days remains", Signature}},
+		// The already annotated activity is skipped, totals restart in the new year
+		{5, []string{"5.00 of 5000.00 km (0.10%) in 2026", "362 days remains"}},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %d entries, got %d", len(expected), len(entries))
+	}
+	for i, e := range expected {
+		if entries[i].Activity.ID != e.id {
+			t.Errorf("entry %d: expected activity %d, got %d", i, e.id, entries[i].Activity.ID)
+		}
+		for _, s := range e.contains {
+			if !strings.Contains(entries[i].Description, s) {
+				t.Errorf("entry %d: %q doesn't contain %q", i, entries[i].Description, s)
+			}
+		}
+	}
+}
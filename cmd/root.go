@@ -23,12 +23,29 @@ var rootAppID string
 var rootAppSecret string
 var rootDBFilename string
 var rootAppVerifyToken string
+var rootLeaderboardGroups []string
+var rootSMTPHost string
+var rootSMTPPort string
+var rootSMTPUsername string
+var rootSMTPPassword string
+var rootSMTPFrom string
+var rootReconcileLookback time.Duration
+var rootReconcileBudget int
 
 // DB is the Bolt db
 var DB *bolt.DB
 
 var AccountCache *ttlcache.Cache[string, int]
 
+// PreviousYearKey identifies cycling activities of the athlete for the year
+type PreviousYearKey struct {
+	AthleteID int
+	Year      int
+}
+
+// PreviousYearCache contains cycling activities of the previous year per athlete
+var PreviousYearCache *ttlcache.Cache[PreviousYearKey, []Activity]
+
 // Version is the version of the application calculated with monova
 var Version string
 
@@ -44,33 +61,68 @@ var rootCmd = &cobra.Command{
 	Short: "go-cycle Strava application",
 	RunE: func(cmd *cobra.Command, args []string) error {
 		Logger.Printf("Starting the application on port %s, domain %s and db %s ...\n", rootPort, rootDomain, rootDBFilename)
-		var err error
-		DB, err = bolt.Open(rootDBFilename, 0644, nil)
+		_, err := parseLeaderboardGroups(rootLeaderboardGroups)
+		if err != nil {
+			return err
+		}
+		err = OpenDB(rootDBFilename)
 		if err != nil {
 			Logger.Fatal(err)
 		}
 		defer DB.Close()
+		err = loadSubscriptionID()
+		if err != nil {
+			Logger.Fatal(err)
+		}
+		go refreshSubscription()
 
 		AccountCache = ttlcache.New[string, int](
 			ttlcache.WithTTL[string, int](30 * time.Minute),
 		)
 		go AccountCache.Start()
 
-		err = DB.Update(func(tx *bolt.Tx) error {
-			_, err := tx.CreateBucketIfNotExists(AccountBucket)
-			return err
-		})
-
-		if err != nil {
-			Logger.Fatal(err)
+		PreviousYearCache = ttlcache.New[PreviousYearKey, []Activity](
+			ttlcache.WithTTL[PreviousYearKey, []Activity](24 * time.Hour),
+		)
+		go PreviousYearCache.Start()
+
+		scheduler := NewScheduler()
+		for _, job := range []struct {
+			name     string
+			schedule string
+			run      func()
+		}{
+			{"goal rollover", "5 0 1 1 *", goalRolloverJob},
+			{"year-end summary", "0 1 * * *", yearEndSummaryJob},
+			{"weekly digest", "0 7 * * 1", weeklyDigestJob},
+			{"falling behind nudges", "0 18 * * *", nudgeJob},
+			{"reconciliation", "30 * * * *", reconcileJob},
+		} {
+			err = scheduler.Register(job.name, job.schedule, job.run)
+			if err != nil {
+				Logger.Fatal(err)
+			}
 		}
+		go scheduler.Start()
+		go runAnnotationWorker()
 
 		http.Handle("/", logMi(rootHandler))
 		http.Handle("/register", logMi(register))
 		http.Handle("/account", logMi(accountHandler))
 		http.Handle("/success", logMi(successHandler))
-		http.Handle("/subscribe", logMi(subscribeToWebhook))
+		http.Handle("/athlete/", logMi(profileHandler))
+		http.Handle("/team/join", logMi(joinTeamHandler))
+		http.Handle("/team/", logMi(teamHandler))
+		http.Handle("/challenge/join", logMi(joinChallengeHandler))
+		http.Handle("/challenge/", logMi(challengeHandler))
+		http.Handle("/leaderboard", logMi(leaderboardHandler))
+		http.Handle("/leaderboard.json", logMi(leaderboardHandler))
+		http.Handle("/unsubscribe", logMi(unsubscribeHandler))
 		http.Handle("/webhook", logMi(webhook))
+		http.Handle("/api/openapi.json", logMi(openAPIHandler))
+		http.Handle("/api/v1/goals", apiMi(apiGoalsHandler))
+		http.Handle("/api/v1/progress", apiMi(apiProgressHandler))
+		http.Handle("/api/v1/activities", apiMi(apiActivitiesHandler))
 
 		if rootPort == "443" {
 			certManager := autocert.Manager{
@@ -130,10 +182,18 @@ func init() {
 	// when this action is called directly.
 	rootCmd.Flags().StringVarP(&rootPort, "port", "p", "8080", "Port to start the server on")
 	rootCmd.Flags().StringVarP(&rootDomain, "domain", "d", "localhost", "Webserver domain name. Used when port is 443 for the certificat retrieval")
-	rootCmd.Flags().StringVarP(&rootAppID, "id", "i", "", "Strava application ID")
-	rootCmd.Flags().StringVarP(&rootAppSecret, "secret", "s", "", "Strava application secret")
-	rootCmd.Flags().StringVarP(&rootDBFilename, "filename", "f", "go-cycle-app.db", "DB filename")
+	rootCmd.PersistentFlags().StringVarP(&rootAppID, "id", "i", "", "Strava application ID")
+	rootCmd.PersistentFlags().StringVarP(&rootAppSecret, "secret", "s", "", "Strava application secret")
+	rootCmd.PersistentFlags().StringVarP(&rootDBFilename, "filename", "f", "go-cycle-app.db", "DB filename")
 	rootCmd.Flags().StringVarP(&rootAppVerifyToken, "token", "t", "", "application verify token. Sent to Strava")
+	rootCmd.Flags().StringVar(&rootSMTPHost, "smtp-host", "", "SMTP server host. Email notifications are disabled if empty")
+	rootCmd.Flags().StringVar(&rootSMTPPort, "smtp-port", "587", "SMTP server port")
+	rootCmd.Flags().StringVar(&rootSMTPUsername, "smtp-username", "", "SMTP username")
+	rootCmd.Flags().StringVar(&rootSMTPPassword, "smtp-password", "", "SMTP password")
+	rootCmd.Flags().StringVar(&rootSMTPFrom, "smtp-from", "go-cycle@localhost", "sender address of email notifications")
+	rootCmd.Flags().DurationVar(&rootReconcileLookback, "reconcile-lookback", 72*time.Hour, "how far back the hourly reconciliation looks for activities with missed webhooks")
+	rootCmd.Flags().IntVar(&rootReconcileBudget, "reconcile-budget", 100, "maximum number of Strava requests the hourly reconciliation can spend")
+	rootCmd.Flags().StringArrayVar(&rootLeaderboardGroups, "group", nil, "leaderboard group of athletes in the name=1,2,3 format. Can be repeated")
 
 	Logger = log.New(os.Stdout, "", log.Lmicroseconds|log.Lshortfile)
 }
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+	bolt "go.etcd.io/bbolt"
+)
+
+// subscriptionDBTimeout is how long the subscription commands wait for the
+// database file, which is locked while the application is running
+var subscriptionDBTimeout = time.Second
+
+// updateStoredSubscription replaces the stored subscription ID with the result
+// of `update`. Returns false if the database is locked by the running
+// application, which looks the subscription up on Strava itself
+func updateStoredSubscription(filename string, update func(stored int) int) (bool, error) {
+	err := openDB(filename, subscriptionDBTimeout)
+	if errors.Is(err, bolt.ErrTimeout) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer DB.Close()
+	stored, err := GetSubscriptionID()
+	if err != nil {
+		return false, err
+	}
+	return true, SetSubscriptionID(update(stored))
+}
+
+// reportStoredSubscription prints whether the subscription ID was stored
+func reportStoredSubscription(out io.Writer, stored bool) {
+	if stored {
+		fmt.Fprintf(out, "updated the stored subscription ID in %s\n", rootDBFilename)
+		return
+	}
+	fmt.Fprintln(out, "the database is locked, the running application updates the subscription ID itself")
+}
+
+// subscriptionCmd represents the subscription command
+var subscriptionCmd = &cobra.Command{
+	Use:   "subscription",
+	Short: "Manage the Strava push subscription",
+	Long: `Creates, shows and deletes the subscription of the application to Strava events.
+The commands only talk to Strava. The running application stores the ID of the
+subscription when it receives the first event and rejects events from other
+subscriptions.`,
+}
+
+var subscriptionCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Subscribe the application to Strava events",
+	Long: `Subscribes https://DOMAIN/webhook to Strava events. Strava validates the callback
+before it responds, so the application has to be running with the same verify
+token.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		subscriptionID, err := createPushSubscription("https://"+rootDomain+"/webhook", rootAppVerifyToken)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "created subscription %d, the running application stores it in %s\n", subscriptionID, SubscriptionValidationDelay)
+		return nil
+	},
+}
+
+var subscriptionViewCmd = &cobra.Command{
+	Use:   "view",
+	Short: "Show the subscription of the application",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		subscription, err := getPushSubscription()
+		if err != nil {
+			return err
+		}
+		if subscription == nil {
+			fmt.Fprintln(cmd.OutOrStdout(), "the application has no subscription")
+			return nil
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "ID:           %d\n", subscription.ID)
+		fmt.Fprintf(cmd.OutOrStdout(), "Callback URL: %s\n", subscription.CallbackURL)
+		fmt.Fprintf(cmd.OutOrStdout(), "Created at:   %s\n", subscription.CreatedAt.Format("2006-01-02 15:04"))
+		return nil
+	},
+}
+
+var subscriptionDeleteCmd = &cobra.Command{
+	Use:   "delete [ID]",
+	Short: "Unsubscribe the application from Strava events",
+	Long:  `Deletes the subscription with the ID, or the current subscription if the ID is omitted.`,
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var subscriptionID int
+		if len(args) == 1 {
+			var err error
+			subscriptionID, err = strconv.Atoi(args[0])
+			if err != nil {
+				return err
+			}
+		} else {
+			subscription, err := getPushSubscription()
+			if err != nil {
+				return err
+			}
+			if subscription == nil {
+				return fmt.Errorf("the application has no subscription")
+			}
+			subscriptionID = subscription.ID
+		}
+		err := deletePushSubscription(subscriptionID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "deleted subscription %d\n", subscriptionID)
+		stored, err := updateStoredSubscription(rootDBFilename, func(stored int) int {
+			if stored == subscriptionID {
+				return 0
+			}
+			return stored
+		})
+		if err != nil {
+			return err
+		}
+		reportStoredSubscription(cmd.OutOrStdout(), stored)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(subscriptionCmd)
+	subscriptionCmd.AddCommand(subscriptionCreateCmd, subscriptionViewCmd, subscriptionDeleteCmd)
+
+	subscriptionCreateCmd.Flags().StringVarP(&rootDomain, "domain", "d", "localhost", "Webserver domain name of the application")
+	subscriptionCreateCmd.Flags().StringVarP(&rootAppVerifyToken, "token", "t", "", "application verify token. Sent to Strava")
+	subscriptionCreateCmd.MarkFlagRequired("token")
+}
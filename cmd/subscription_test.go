@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func Test_updateStoredSubscription(t *testing.T) {
+	previous, timeout := DB, subscriptionDBTimeout
+	subscriptionDBTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { DB, subscriptionDBTimeout = previous, timeout })
+	filename := filepath.Join(t.TempDir(), "test.db")
+
+	stored, err := updateStoredSubscription(filename, func(int) int { return 42 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored {
+		t.Fatalf("expected the subscription to be stored")
+	}
+	stored, err = updateStoredSubscription(filename, func(stored int) int {
+		if stored != 42 {
+			t.Errorf("expected stored subscription 42, got %d", stored)
+		}
+		return 0
+	})
+	if err != nil || !stored {
+		t.Fatalf("expected the subscription to be cleared, got %v", err)
+	}
+
+	// the running application holds the lock
+	locked, err := bolt.Open(filename, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locked.Close()
+	stored, err = updateStoredSubscription(filename, func(int) int { return 7 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored {
+		t.Errorf("expected the locked database to be skipped")
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+var undoAthleteID int
+var undoActivityID int
+var undoYear int
+var undoAll bool
+
+// undoCmd represents the undo command
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Remove the progress block from activities of the athlete",
+	Long: `Restores descriptions activities had before they were annotated. Use --activity
+for one activity, --year for all activities of the year or --all when the athlete
+leaves. Restored activities are never annotated again. The command is stopped when
+Strava rejects an update, e.g. because of the rate limit, and can be safely started
+again later.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		selected := 0
+		for _, set := range []bool{undoActivityID != 0, undoYear != 0, undoAll} {
+			if set {
+				selected++
+			}
+		}
+		if selected != 1 {
+			return fmt.Errorf("exactly one of --activity, --year and --all is required")
+		}
+		err := OpenDB(rootDBFilename)
+		if err != nil {
+			return err
+		}
+		defer DB.Close()
+
+		if undoActivityID != 0 {
+			accessToken, err := RefreshAccessToken(undoAthleteID)
+			if err != nil {
+				return err
+			}
+			restored, err := undoActivity(undoAthleteID, accessToken, undoActivityID)
+			if err != nil {
+				return err
+			}
+			if !restored {
+				fmt.Fprintf(cmd.OutOrStdout(), "activity %d has nothing to restore\n", undoActivityID)
+				return nil
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "restored activity %d\n", undoActivityID)
+			return nil
+		}
+		restored, err := undoAnnotations(undoAthleteID, undoYear)
+		fmt.Fprintf(cmd.OutOrStdout(), "restored %d activities\n", restored)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(undoCmd)
+
+	undoCmd.Flags().IntVar(&undoAthleteID, "athlete", 0, "Strava athlete ID")
+	undoCmd.Flags().IntVar(&undoActivityID, "activity", 0, "restore the activity")
+	undoCmd.Flags().IntVar(&undoYear, "year", 0, "restore all activities of the year")
+	undoCmd.Flags().BoolVar(&undoAll, "all", false, "restore all activities")
+	undoCmd.MarkFlagRequired("athlete")
+}
@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIToken is a personal token which gives access to the JSON API. Only the
+// hash of the token is stored
+type APIToken struct {
+	Hash      string    `json:"hash"`
+	AthleteID int       `json:"athlete_id"`
+	Label     string    `json:"label"`
+	Prefix    string    `json:"prefix"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIGoal is a goal of the athlete in the JSON API
+type APIGoal struct {
+	Year     int      `json:"year"`
+	Goal     float64  `json:"goal"`
+	GearIDs  []string `json:"gear_ids"`
+	Distance float64  `json:"distance"`
+	Progress float64  `json:"progress"`
+	Achieved bool     `json:"achieved"`
+}
+
+// APIProgress is the progress of the athlete in the current year in the JSON API
+type APIProgress struct {
+	Year         int        `json:"year"`
+	Goal         float64    `json:"goal"`
+	Distance     float64    `json:"distance"`
+	Progress     float64    `json:"progress"`
+	DistanceLeft float64    `json:"distance_left"`
+	DaysLeft     int        `json:"days_left"`
+	Rides        int        `json:"rides"`
+	Elevation    float64    `json:"elevation"`
+	Streaks      *Streaks   `json:"streaks"`
+	Eddington    *Eddington `json:"eddington"`
+}
+
+// APIError is the body of unsuccessful JSON API responses
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// hashAPIToken returns the hash of the token which is stored in the database
+func hashAPIToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// createAPIToken generates a new token for the athlete. The token itself is
+// returned only once
+func createAPIToken(athleteID int, label string) (string, error) {
+	secret, err := GenerateRandomID(30)
+	if err != nil {
+		return "", err
+	}
+	token := "gca_" + secret
+	err = SaveAPIToken(&APIToken{
+		Hash:      hashAPIToken(token),
+		AthleteID: athleteID,
+		Label:     label,
+		Prefix:    token[:8],
+		CreatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+// apiMi authenticates requests to the JSON API with the personal token from
+// the Authorization header
+func apiMi(next func(w http.ResponseWriter, r *http.Request, athleteID int)) http.HandlerFunc {
+	return logMi(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			writeJSON(w, http.StatusUnauthorized, APIError{Error: "missing API token"})
+			return
+		}
+		apiToken, err := GetAPIToken(hashAPIToken(token))
+		if err != nil {
+			writeJSON(w, http.StatusUnauthorized, APIError{Error: "invalid API token"})
+			return
+		}
+		next(w, r, apiToken.AthleteID)
+	})
+}
+
+// openAPIHandler serves OpenAPI specification of the JSON API
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := TemplatesStorage.ReadFile("templates/openapi.json")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// apiGoalsHandler lists goals of the athlete and sets the goal of the year
+func apiGoalsHandler(w http.ResponseWriter, r *http.Request, athleteID int) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		summaries, err := getYearSummaries(athleteID)
+		if err != nil {
+			logger.Println(err)
+			writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+			return
+		}
+		goals := []APIGoal{}
+		for _, summary := range summaries {
+			if summary.Goal == 0 {
+				continue
+			}
+			gearIDs, err := GetGoalGear(athleteID, summary.Year)
+			if err != nil {
+				logger.Println(err)
+				writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+				return
+			}
+			if gearIDs == nil {
+				gearIDs = []string{}
+			}
+			goals = append(goals, APIGoal{
+				Year:     summary.Year,
+				Goal:     summary.Goal,
+				GearIDs:  gearIDs,
+				Distance: summary.GoalDistance,
+				Progress: summary.Progress(),
+				Achieved: summary.Achieved(),
+			})
+		}
+		writeJSON(w, http.StatusOK, goals)
+	case http.MethodPost:
+		var goal APIGoal
+		err := json.NewDecoder(r.Body).Decode(&goal)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: err.Error()})
+			return
+		}
+		if goal.Year == 0 || goal.Goal <= 0 {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: "year and goal are required"})
+			return
+		}
+		err = SetGoal(athleteID, goal.Year, goal.Goal/1000)
+		if err == nil {
+			err = SetGoalGear(athleteID, goal.Year, goal.GearIDs)
+		}
+		if err != nil {
+			logger.Println(err)
+			writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+			return
+		}
+		if goal.GearIDs == nil {
+			goal.GearIDs = []string{}
+		}
+		goal.Distance = 0
+		goal.Progress = 0
+		goal.Achieved = false
+		writeJSON(w, http.StatusCreated, goal)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, APIError{Error: "method not allowed"})
+	}
+}
+
+// apiProgressHandler returns progress of the athlete in the current year
+func apiProgressHandler(w http.ResponseWriter, r *http.Request, athleteID int) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, APIError{Error: "method not allowed"})
+		return
+	}
+
+	year := time.Now().Year()
+	summaries, err := getYearSummaries(athleteID)
+	if err != nil {
+		logger.Println(err)
+		writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+		return
+	}
+	progress := APIProgress{
+		Year:     year,
+		DaysLeft: int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1),
+	}
+	for _, summary := range summaries {
+		if summary.Year == year {
+			progress.Goal = summary.Goal
+			progress.Distance = summary.GoalDistance
+			progress.Progress = summary.Progress()
+			progress.Rides = summary.Rides
+			progress.Elevation = summary.Elevation
+			if summary.Goal > summary.GoalDistance {
+				progress.DistanceLeft = summary.Goal - summary.GoalDistance
+			}
+		}
+	}
+	progress.Streaks, err = getStreaks(athleteID)
+	if err == nil {
+		progress.Eddington, err = getEddington(athleteID, 0)
+	}
+	if err != nil {
+		logger.Println(err)
+		writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, progress)
+}
+
+// apiActivitiesHandler returns stored cycling activities of the athlete,
+// optionally filtered by year
+func apiActivitiesHandler(w http.ResponseWriter, r *http.Request, athleteID int) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, APIError{Error: "method not allowed"})
+		return
+	}
+
+	year := 0
+	if r.URL.Query().Get("year") != "" {
+		var err error
+		year, err = strconv.Atoi(r.URL.Query().Get("year"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, APIError{Error: "invalid year"})
+			return
+		}
+	}
+
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		logger.Println(err)
+		writeJSON(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+		return
+	}
+	result := []Activity{}
+	for _, activity := range activities {
+		if year == 0 || activity.StartDateLocal.Year() == year {
+			result = append(result, activity)
+		}
+	}
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,323 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// openAPISchema is the subset of the OpenAPI schema object used by the spec
+type openAPISchema struct {
+	Ref                  string                    `json:"$ref"`
+	Type                 string                    `json:"type"`
+	Required             []string                  `json:"required"`
+	Properties           map[string]*openAPISchema `json:"properties"`
+	Items                *openAPISchema            `json:"items"`
+	AdditionalProperties *bool                     `json:"additionalProperties"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema *openAPISchema `json:"schema"`
+	} `json:"content"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]*openAPIResponse `json:"responses"`
+}
+
+type openAPISpec struct {
+	Paths      map[string]map[string]*openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]*openAPISchema   `json:"schemas"`
+		Responses map[string]*openAPIResponse `json:"responses"`
+	} `json:"components"`
+}
+
+func loadOpenAPISpec(t *testing.T) *openAPISpec {
+	data, err := TemplatesStorage.ReadFile("templates/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec := &openAPISpec{}
+	err = json.Unmarshal(data, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return spec
+}
+
+// responseSchema returns the schema of the documented response
+func (s *openAPISpec) responseSchema(path string, method string, status int) (*openAPISchema, error) {
+	operation, ok := s.Paths[path][strings.ToLower(method)]
+	if !ok {
+		return nil, fmt.Errorf("%s %s isn't documented", method, path)
+	}
+	response := operation.Responses[fmt.Sprint(status)]
+	if response == nil {
+		return nil, fmt.Errorf("%s %s doesn't document status %d", method, path, status)
+	}
+	if response.Ref != "" {
+		response = s.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+	return response.Content["application/json"].Schema, nil
+}
+
+// validate checks that the decoded JSON value conforms to the schema
+func (s *openAPISpec) validate(schema *openAPISchema, value interface{}, path string) error {
+	if schema.Ref != "" {
+		resolved, ok := s.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+		if !ok {
+			return fmt.Errorf("%s: unknown schema %s", path, schema.Ref)
+		}
+		schema = resolved
+	}
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected object, got %v", path, value)
+		}
+		for _, name := range schema.Required {
+			if _, ok := object[name]; !ok {
+				return fmt.Errorf("%s: missing required property %s", path, name)
+			}
+		}
+		for name, property := range object {
+			propertySchema, ok := schema.Properties[name]
+			if !ok {
+				if schema.AdditionalProperties != nil && !*schema.AdditionalProperties {
+					return fmt.Errorf("%s: undocumented property %s", path, name)
+				}
+				continue
+			}
+			err := s.validate(propertySchema, property, path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: expected array, got %v", path, value)
+		}
+		for i, item := range array {
+			err := s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return err
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			return fmt.Errorf("%s: expected string, got %v", path, value)
+		}
+	case "number", "integer":
+		number, ok := value.(float64)
+		if !ok {
+			return fmt.Errorf("%s: expected %s, got %v", path, schema.Type, value)
+		}
+		if schema.Type == "integer" && number != float64(int64(number)) {
+			return fmt.Errorf("%s: expected integer, got %v", path, value)
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%s: expected boolean, got %v", path, value)
+		}
+	}
+	return nil
+}
+
+// setupTestDB opens an empty database with the athlete 1
+func setupTestDB(t *testing.T) {
+	var err error
+	DB, err = bolt.Open(filepath.Join(t.TempDir(), "test.db"), 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { DB.Close() })
+	err = CreateBuckets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveAuthData(1, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupAPITest(t *testing.T) string {
+	setupTestDB(t)
+	now := time.Now().UTC()
+	err := SetGoal(1, now.Year(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveActivities(1, []Activity{
+		{ID: 1, Name: "Morning Ride", SportType: "Ride", Distance: 42000, MovingTime: 5400, StartDate: now, StartDateLocal: now, GearID: "b1"},
+		{ID: 2, Name: "Gravel", SportType: "GravelRide", Distance: 30000, StartDate: now.AddDate(-1, 0, 0), StartDateLocal: now.AddDate(-1, 0, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := createAPIToken(1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func Test_openAPIHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	openAPIHandler(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var spec map[string]interface{}
+	err := json.Unmarshal(w.Body.Bytes(), &spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(spec["openapi"].(string), "3.") {
+		t.Errorf("expected OpenAPI 3 document, got %v", spec["openapi"])
+	}
+}
+
+func Test_API_conformance(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	token := setupAPITest(t)
+	handlers := map[string]func(http.ResponseWriter, *http.Request, int){
+		"/goals":      apiGoalsHandler,
+		"/progress":   apiProgressHandler,
+		"/activities": apiActivitiesHandler,
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		query  string
+		body   string
+		token  string
+		status int
+	}{
+		{http.MethodGet, "/goals", "", "", token, http.StatusOK},
+		{http.MethodPost, "/goals", "", `{"year": 2030, "goal": 5000000, "gear_ids": ["b1"]}`, token, http.StatusCreated},
+		{http.MethodPost, "/goals", "", `{"year": 2030}`, token, http.StatusBadRequest},
+		{http.MethodGet, "/goals", "", "", "", http.StatusUnauthorized},
+		{http.MethodGet, "/progress", "", "", token, http.StatusOK},
+		{http.MethodGet, "/progress", "", "", "gca_invalid", http.StatusUnauthorized},
+		{http.MethodGet, "/activities", "", "", token, http.StatusOK},
+		{http.MethodGet, "/activities", "?year=2000", "", token, http.StatusOK},
+		{http.MethodGet, "/activities", "?year=last", "", token, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		name := tt.method + " " + tt.path + tt.query
+		t.Run(name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1"+tt.path+tt.query, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			apiMi(handlers[tt.path]).ServeHTTP(w, r)
+
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d: %s", tt.status, w.Code, w.Body.String())
+			}
+			if w.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("expected JSON response, got %s", w.Header().Get("Content-Type"))
+			}
+			schema, err := spec.responseSchema(tt.path, tt.method, w.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var body interface{}
+			err = json.Unmarshal(w.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = spec.validate(schema, body, "body")
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func Test_API_activities_not_empty(t *testing.T) {
+	token := setupAPITest(t)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/activities", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	apiMi(apiActivitiesHandler).ServeHTTP(w, r)
+
+	var activities []Activity
+	err := json.Unmarshal(w.Body.Bytes(), &activities)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activities) != 2 {
+		t.Errorf("expected 2 activities, got %d", len(activities))
+	}
+}
+
+func Test_openAPI_schemas_match_types(t *testing.T) {
+	spec := loadOpenAPISpec(t)
+	types := map[string]interface{}{
+		"Goal":      APIGoal{},
+		"Progress":  APIProgress{},
+		"Streaks":   Streaks{},
+		"Eddington": Eddington{},
+		"Activity":  Activity{},
+		"Error":     APIError{},
+	}
+	for name, value := range types {
+		schema := spec.Components.Schemas[name]
+		if schema == nil {
+			t.Errorf("schema %s is missing", name)
+			continue
+		}
+		fields := reflect.TypeOf(value)
+		for i := 0; i < fields.NumField(); i++ {
+			tag, _, _ := strings.Cut(fields.Field(i).Tag.Get("json"), ",")
+			if _, ok := schema.Properties[tag]; !ok {
+				t.Errorf("schema %s doesn't document field %s", name, tag)
+			}
+		}
+		if len(schema.Properties) != fields.NumField() {
+			t.Errorf("schema %s has %d properties, type has %d fields", name, len(schema.Properties), fields.NumField())
+		}
+	}
+}
+
+func Test_apiMi_authorization(t *testing.T) {
+	token := setupAPITest(t)
+	for _, tc := range []struct {
+		header string
+		status int
+	}{
+		{"Bearer " + token, http.StatusOK},
+		{token, http.StatusUnauthorized},
+		{"Basic " + token, http.StatusUnauthorized},
+		{"bearer " + token, http.StatusUnauthorized},
+		{"Bearer ", http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/goals", nil)
+		if tc.header != "" {
+			r.Header.Set("Authorization", tc.header)
+		}
+		w := httptest.NewRecorder()
+		apiMi(apiGoalsHandler).ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("%q: expected status %d, got %d", tc.header, tc.status, w.Code)
+		}
+	}
+}
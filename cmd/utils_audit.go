@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"time"
+)
+
+// Actions recorded in the audit log
+const (
+	AuditAnnotate = "annotate"
+	AuditUndo     = "undo"
+)
+
+// AuditLogSize is the number of audit entries shown on the account page
+const AuditLogSize = 20
+
+// AuditEntry is one edit of the activity description
+// Notes:
+//   - `Original` is the description before the edit and `Description` is the
+//     one sent to Strava
+//   - `StatusCode` is 0 if Strava didn't respond
+type AuditEntry struct {
+	ActivityID  int       `json:"activity_id"`
+	Action      string    `json:"action"`
+	Original    string    `json:"original"`
+	Description string    `json:"description"`
+	StatusCode  int       `json:"status_code"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Succeeded reports whether Strava accepted the edit
+func (e AuditEntry) Succeeded() bool {
+	return e.Error == ""
+}
+
+// editActivityDescription replaces description of the activity on Strava and
+// records the edit in the audit log, whether it succeeded or not
+func editActivityDescription(athleteID int, accessToken string, activityID int, action string, original string, description string) error {
+	statusCode, err := updateActivityDescription(accessToken, activityID, description)
+	entry := &AuditEntry{
+		ActivityID:  activityID,
+		Action:      action,
+		Original:    original,
+		Description: description,
+		StatusCode:  statusCode,
+		CreatedAt:   time.Now().UTC(),
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	auditErr := AddAuditEntry(athleteID, entry)
+	if auditErr != nil {
+		Logger.Printf("failed to audit the edit of activity %d: %s\n", activityID, auditErr)
+	}
+	return err
+}
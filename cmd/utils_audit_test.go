@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"testing"
+)
+
+func Test_GetAuditLog(t *testing.T) {
+	setupTestDB(t)
+	entries, err := GetAuditLog(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected empty audit log, got %d entries", len(entries))
+	}
+
+	// More than 10 entries to check that keys are ordered numerically
+	for i := 1; i <= 12; i++ {
+		err = AddAuditEntry(1, &AuditEntry{ActivityID: i, Action: AuditAnnotate, StatusCode: 200})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = AddAuditEntry(1, &AuditEntry{ActivityID: 1, Action: AuditUndo, Error: "failed to update activity 1: 429 Too Many Requests", StatusCode: 429})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err = GetAuditLog(1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries, got %d", len(entries))
+	}
+	if entries[0].Action != AuditUndo || entries[0].Succeeded() {
+		t.Errorf("expected the failed undo first, got %+v", entries[0])
+	}
+	if entries[1].ActivityID != 12 || entries[2].ActivityID != 11 || !entries[1].Succeeded() {
+		t.Errorf("unexpected order %+v", entries)
+	}
+
+	entries, _ = GetAuditLog(1, 0)
+	if len(entries) != 13 {
+		t.Errorf("expected all 13 entries, got %d", len(entries))
+	}
+	entries, _ = GetAuditLog(2, 0)
+	if len(entries) != 0 {
+		t.Errorf("expected no entries of other athletes, got %d", len(entries))
+	}
+}
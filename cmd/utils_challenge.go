@@ -0,0 +1,287 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Supported challenge metrics
+var ChallengeMetrics = []string{"distance", "elevation", "rides"}
+
+// Challenge is a time-boxed competition between athletes
+// Notes:
+//   - `Start` and `End` are dates in athlete's time zone, both inclusive
+//   - `Target` is in meters for distance and elevation, or number of rides
+//   - empty `SportTypes` means that all cycling activities count
+type Challenge struct {
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Start        time.Time `json:"start"`
+	End          time.Time `json:"end"`
+	Metric       string    `json:"metric"`
+	Target       float64   `json:"target"`
+	SportTypes   []string  `json:"sport_types"`
+	OrganiserID  int       `json:"organiser_id"`
+	Participants []int     `json:"participants"`
+	InviteCode   string    `json:"invite_code"`
+}
+
+// Includes reports whether the activity counts towards the challenge
+func (c *Challenge) Includes(activity Activity) bool {
+	day := localDay(activity.StartDateLocal)
+	if day.Before(c.Start) || day.After(c.End) {
+		return false
+	}
+	return len(c.SportTypes) == 0 || slices.Contains(c.SportTypes, activity.SportType)
+}
+
+// IsActive reports whether the challenge is running on the day of `now`
+func (c *Challenge) IsActive(now time.Time) bool {
+	day := localDay(now)
+	return !day.Before(c.Start) && !day.After(c.End)
+}
+
+// Format formats the value of the challenge metric for humans
+func (c *Challenge) Format(value float64) string {
+	switch c.Metric {
+	case "elevation":
+		return fmt.Sprintf("%.0f m", value)
+	case "rides":
+		return fmt.Sprintf("%.0f rides", value)
+	default:
+		return fmt.Sprintf("%.2f km", value/1000)
+	}
+}
+
+// ChallengeStanding is the result of the participant of the challenge
+type ChallengeStanding struct {
+	Rank      int
+	AthleteID int
+	Name      string
+	Value     float64
+}
+
+// ChallengeStatus is the state of the challenge for one of the participants
+type ChallengeStatus struct {
+	*Challenge
+	Standing     ChallengeStanding
+	Participants int
+}
+
+// computeChallengeStandings ranks participants by the challenge metric
+func computeChallengeStandings(challenge *Challenge, activities map[int][]Activity) []ChallengeStanding {
+	standings := []ChallengeStanding{}
+	for _, athleteID := range challenge.Participants {
+		standing := ChallengeStanding{AthleteID: athleteID}
+		for _, activity := range activities[athleteID] {
+			if !challenge.Includes(activity) {
+				continue
+			}
+			switch challenge.Metric {
+			case "elevation":
+				standing.Value += activity.TotalElevationGain
+			case "rides":
+				standing.Value++
+			default:
+				standing.Value += activity.Distance
+			}
+		}
+		standings = append(standings, standing)
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		return standings[i].Value > standings[j].Value
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+		if i > 0 && standings[i].Value == standings[i-1].Value {
+			standings[i].Rank = standings[i-1].Rank
+		}
+	}
+	return standings
+}
+
+// getChallengeStandings calculates standings from the stored activities of
+// the participants
+func getChallengeStandings(challenge *Challenge) ([]ChallengeStanding, error) {
+	activities := map[int][]Activity{}
+	for _, athleteID := range challenge.Participants {
+		athleteActivities, err := GetActivities(athleteID)
+		if err != nil {
+			return nil, err
+		}
+		activities[athleteID] = athleteActivities
+	}
+	standings := computeChallengeStandings(challenge, activities)
+	for i := range standings {
+		standings[i].Name = GetAthleteName(standings[i].AthleteID)
+	}
+	return standings, nil
+}
+
+// getAthleteChallenges returns status of the challenges the athlete takes
+// part in. If `activeOnly` is set, only running challenges are returned
+func getAthleteChallenges(athleteID int, activeOnly bool) ([]*ChallengeStatus, error) {
+	challenges, err := GetChallenges()
+	if err != nil {
+		return nil, err
+	}
+	result := []*ChallengeStatus{}
+	for _, challenge := range challenges {
+		if !slices.Contains(challenge.Participants, athleteID) && challenge.OrganiserID != athleteID {
+			continue
+		}
+		if activeOnly && !challenge.IsActive(time.Now()) {
+			continue
+		}
+		standings, err := getChallengeStandings(challenge)
+		if err != nil {
+			return nil, err
+		}
+		status := &ChallengeStatus{Challenge: challenge, Participants: len(standings)}
+		for _, standing := range standings {
+			if standing.AthleteID == athleteID {
+				status.Standing = standing
+			}
+		}
+		result = append(result, status)
+	}
+	return result, nil
+}
+
+// updateChallenges creates or leaves the challenge based on the submitted
+// account form
+func updateChallenges(athleteID int, r *http.Request) error {
+	switch r.FormValue("action") {
+	case "createChallenge":
+		start, err := time.Parse("2006-01-02", r.FormValue("start"))
+		if err != nil {
+			return err
+		}
+		end, err := time.Parse("2006-01-02", r.FormValue("end"))
+		if err != nil {
+			return err
+		}
+		if end.Before(start) {
+			return fmt.Errorf("challenge can't end before it starts")
+		}
+		metric := r.FormValue("metric")
+		if !slices.Contains(ChallengeMetrics, metric) {
+			return fmt.Errorf("unknown metric %s", metric)
+		}
+		target, err := strconv.ParseFloat(r.FormValue("target"), 64)
+		if err != nil {
+			return err
+		}
+		if metric == "distance" {
+			target = target * 1000
+		}
+		for _, sportType := range r.Form["sportType"] {
+			if !slices.Contains(CyclingActivities, sportType) {
+				return fmt.Errorf("unknown sport type %s", sportType)
+			}
+		}
+		id, err := GenerateRandomID(6)
+		if err != nil {
+			return err
+		}
+		inviteCode, err := GenerateRandomID(12)
+		if err != nil {
+			return err
+		}
+		return SaveChallenge(&Challenge{
+			ID:           id,
+			Name:         strings.TrimSpace(r.FormValue("name")),
+			Start:        start,
+			End:          end,
+			Metric:       metric,
+			Target:       target,
+			SportTypes:   r.Form["sportType"],
+			OrganiserID:  athleteID,
+			Participants: []int{athleteID},
+			InviteCode:   inviteCode,
+		})
+	case "leaveChallenge":
+		return UpdateChallenge(r.FormValue("id"), func(challenge *Challenge) error {
+			participants := []int{}
+			for _, participant := range challenge.Participants {
+				if participant != athleteID {
+					participants = append(participants, participant)
+				}
+			}
+			challenge.Participants = participants
+			return nil
+		})
+	}
+	return nil
+}
+
+// joinChallengeHandler adds the athlete to the challenge using the join link
+func joinChallengeHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	challenge, err := GetChallengeByInviteCode(r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, "Challenge not found", http.StatusNotFound)
+		return
+	}
+
+	athleteID, ok := loggedInAthlete(w, r)
+	if !ok {
+		return
+	}
+
+	joined := false
+	err = UpdateChallenge(challenge.ID, func(challenge *Challenge) error {
+		if !slices.Contains(challenge.Participants, athleteID) {
+			challenge.Participants = append(challenge.Participants, athleteID)
+			joined = true
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if joined {
+		logger.Printf("athlete %d joined challenge %s\n", athleteID, challenge.ID)
+	}
+	http.Redirect(w, r, "https://"+rootDomain+"/challenge/"+challenge.ID, http.StatusFound)
+}
+
+// challengeHandler renders the standings page of the challenge
+func challengeHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	challenge, err := GetChallenge(strings.TrimPrefix(r.URL.Path, "/challenge/"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	standings, err := getChallengeStandings(challenge)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderHTML(w, "templates/challenge.html", map[string]interface{}{
+		"Challenge": challenge,
+		"Standings": standings,
+		"Domain":    rootDomain,
+	})
+}
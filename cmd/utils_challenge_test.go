@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_computeChallengeStandings(t *testing.T) {
+	challenge := &Challenge{
+		Start:        time.Date(2023, time.October, 1, 0, 0, 0, 0, time.UTC),
+		End:          time.Date(2023, time.October, 31, 0, 0, 0, 0, time.UTC),
+		Metric:       "distance",
+		SportTypes:   []string{"Ride", "GravelRide"},
+		Participants: []int{1, 2, 3},
+	}
+	activities := map[int][]Activity{
+		1: {
+			{Distance: 10000, SportType: "Ride", StartDateLocal: time.Date(2023, time.October, 31, 22, 0, 0, 0, time.UTC)},
+			{Distance: 90000, SportType: "Ride", StartDateLocal: time.Date(2023, time.November, 1, 8, 0, 0, 0, time.UTC)},
+		},
+		2: {
+			{Distance: 20000, SportType: "GravelRide", StartDateLocal: time.Date(2023, time.October, 1, 8, 0, 0, 0, time.UTC)},
+			{Distance: 90000, SportType: "VirtualRide", StartDateLocal: time.Date(2023, time.October, 2, 8, 0, 0, 0, time.UTC)},
+		},
+	}
+
+	standings := computeChallengeStandings(challenge, activities)
+	expected := []ChallengeStanding{
+		{Rank: 1, AthleteID: 2, Value: 20000},
+		{Rank: 2, AthleteID: 1, Value: 10000},
+		{Rank: 3, AthleteID: 3, Value: 0},
+	}
+	for i := range expected {
+		if standings[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected, standings)
+			break
+		}
+	}
+
+	challenge.Metric = "rides"
+	standings = computeChallengeStandings(challenge, activities)
+	if standings[0].Value != 1 || standings[0].Rank != 1 || standings[1].Rank != 1 {
+		t.Errorf("expected shared first place, got %+v", standings)
+	}
+}
+
+func Test_UpdateChallenge_concurrent(t *testing.T) {
+	setupTestDB(t)
+	err := SaveChallenge(&Challenge{ID: "c", Participants: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for athleteID := 2; athleteID <= 11; athleteID++ {
+		wg.Add(1)
+		go func(athleteID int) {
+			defer wg.Done()
+			err := UpdateChallenge("c", func(challenge *Challenge) error {
+				challenge.Participants = append(challenge.Participants, athleteID)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(athleteID)
+	}
+	wg.Wait()
+
+	challenge, err := GetChallenge("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(challenge.Participants) != 11 {
+		t.Errorf("expected 11 participants, got %v", challenge.Participants)
+	}
+}
@@ -2,20 +2,98 @@ package cmd
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
 // DB structure:
-// 1. AccountBucket - contains all information about Strava athlete: access token and athlet's goal
+// 1. AccountBucket - contains all information about Strava athlete: access token, settings and
+//    athlet's goals. Goals are stored in the nested `goals` bucket with year as a key. Bikes
+//    which count towards the goal are stored in the nested `goalGear` bucket with year as a key.
+//    IDs of annotated activities are stored in the nested `annotated` bucket with the time of
+//    the annotation as a value. Descriptions activities had before the annotation are stored in
+//    the nested `originals` bucket and IDs of activities whose annotation was undone or discarded
+//    in the preview are stored in the nested `reverted` bucket, both with activity ID as a key.
+//    Descriptions waiting for approval in the preview mode are stored in the nested `outbox`
+//    bucket with activity ID as a key. The number of failed attempts to annotate the activity is
+//    stored in the nested `failures` bucket with activity ID as a key
+// 2. ActivityBucket - contains cycling activities of the athlete, fetched from Strava. Every
+//    athlete has a nested bucket with activity ID as a key and JSON encoded activity as a value
+// 3. SummaryBucket - contains year-end summaries. Every athlete has a nested bucket with year as a
+//    key and JSON encoded summary as a value
+// 4. TeamBucket - contains teams with team ID as a key and JSON encoded team as a value
+// 5. ChallengeBucket - contains challenges with challenge ID as a key and JSON encoded challenge
+//    as a value
+// 6. APITokenBucket - contains personal API tokens with token hash as a key and JSON encoded
+//    token information as a value
+// 7. JobBucket - contains state of the scheduled jobs with job name as a key and JSON encoded
+//    state as a value
+// 8. AuditBucket - contains edits of activity descriptions. Every athlete has a nested bucket
+//    with zero padded sequence number as a key and JSON encoded audit entry as a value
+// 9. AppBucket - contains application-wide values, e.g. ID of the Strava push subscription
+// 10. UnsubscribeBucket - contains unsubscribe tokens from email links with token as a key and
+//    athlete ID as a value
 
 var AccountBucket = []byte("account")
+var ActivityBucket = []byte("activity")
+var SummaryBucket = []byte("summary")
+var TeamBucket = []byte("team")
+var ChallengeBucket = []byte("challenge")
+var APITokenBucket = []byte("apiToken")
+var JobBucket = []byte("job")
+var AuditBucket = []byte("audit")
+var AppBucket = []byte("app")
+var UnsubscribeBucket = []byte("unsubscribe")
+
+// Buckets contains all top-level buckets
+var Buckets = [][]byte{AccountBucket, ActivityBucket, SummaryBucket, TeamBucket, ChallengeBucket, APITokenBucket, JobBucket, AuditBucket, AppBucket, UnsubscribeBucket}
+
+// OpenDB opens the database file, creates missing buckets and migrates old
+// data. Fails if the file is locked by another process for too long
+func OpenDB(filename string) error {
+	return openDB(filename, 10*time.Second)
+}
+
+// openDB opens the database, waiting at most `timeout` for the file lock
+func openDB(filename string, timeout time.Duration) error {
+	var err error
+	DB, err = bolt.Open(filename, 0644, &bolt.Options{Timeout: timeout})
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filename, err)
+	}
+	err = CreateBuckets()
+	if err != nil {
+		return err
+	}
+	err = MigrateLegacyGoals()
+	if err != nil {
+		return err
+	}
+	return IndexUnsubscribeTokens()
+}
+
+// CreateBuckets creates all top-level buckets which don't exist yet
+func CreateBuckets() error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		for _, name := range Buckets {
+			_, err := tx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
 
 // RefreshAccessToken refresh access token
 func RefreshAccessToken(athleteID int) (string, error) {
@@ -103,12 +181,14 @@ func SaveAuthData(athleteID int, data *StravaResponseRefresh) error {
 		if err != nil {
 			return err
 		}
-		return nil
+		// New tokens give the reconciliation another chance
+		return athleteBucket.Delete([]byte("reconcileFailures"))
 	})
 	return err
 }
 
-func SetGoal(athleteID int, goal float64) error {
+// SetGoal sets the goal of the athlete for the year. `goal` is in km
+func SetGoal(athleteID int, year int, goal float64) error {
 	err := DB.Update(func(tx *bolt.Tx) error {
 		authBucket := tx.Bucket(AccountBucket)
 
@@ -117,28 +197,1454 @@ func SetGoal(athleteID int, goal float64) error {
 			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
 		}
 
-		err := bucket.Put([]byte("goal"), []byte(fmt.Sprintf("%f", goal*1000)))
+		goalsBucket, err := bucket.CreateBucketIfNotExists([]byte("goals"))
+		if err != nil {
+			return err
+		}
+
+		err = goalsBucket.Put([]byte(strconv.Itoa(year)), []byte(fmt.Sprintf("%f", goal*1000)))
+		return err
+	})
+	return err
+}
+
+// ErrGoalNotFound is returned when the athlete has no goal for the year
+var ErrGoalNotFound = errors.New("goal is not found")
+
+// GetGoal returns the goal of the athlete for the year in meters. If the goal
+// for the year is not set, it is rolled over from the previous goal according
+// to athlete's rollover settings. Rolled over goals are saved only by
+// RolloverGoal
+func GetGoal(athleteID int, year int) (float64, error) {
+	goal, _, err := rolledOverGoal(athleteID, year)
+	return goal, err
+}
+
+// rolledOverGoal returns the goal of the athlete for the year and the year of
+// the goal it was rolled over from. The year is 0 if the goal is set
+func rolledOverGoal(athleteID int, year int) (float64, int, error) {
+	goals, err := GetGoals(athleteID)
+	if err != nil {
+		return 0, 0, err
+	}
+	goal, ok := goals[year]
+	if ok {
+		return goal, 0, nil
+	}
+
+	// Find the most recent goal before the year
+	previousYear := 0
+	for goalYear := range goals {
+		if goalYear < year && goalYear > previousYear {
+			previousYear = goalYear
+		}
+	}
+	if previousYear == 0 {
+		return 0, 0, fmt.Errorf("athleteID %d: %w", athleteID, ErrGoalNotFound)
+	}
+
+	rollover, err := GetRolloverSettings(athleteID)
+	if err != nil {
+		return 0, 0, err
+	}
+	switch rollover.Mode {
+	case RolloverCopy:
+		goal = goals[previousYear]
+	case RolloverIncrease:
+		goal = goals[previousYear]
+		for i := previousYear; i < year; i++ {
+			goal = goal * (1 + rollover.Percent/100)
+		}
+	default:
+		return 0, 0, fmt.Errorf("athleteID %d, %d: %w", athleteID, year, ErrGoalNotFound)
+	}
+	return goal, previousYear, nil
+}
+
+// RolloverGoal saves the rolled over goal of the athlete for the year together
+// with bikes of the previous goal. Does nothing if the goal is already set
+func RolloverGoal(athleteID int, year int) error {
+	goal, previousYear, err := rolledOverGoal(athleteID, year)
+	if err != nil || previousYear == 0 {
 		return err
+	}
+	err = SetGoal(athleteID, year, goal/1000)
+	if err != nil {
+		return err
+	}
+	gearIDs, err := GetGoalGear(athleteID, previousYear)
+	if err != nil {
+		return err
+	}
+	if len(gearIDs) > 0 {
+		return SetGoalGear(athleteID, year, gearIDs)
+	}
+	return nil
+}
+
+// SetGoalGear restricts the goal of the athlete for the year to the bikes.
+// Empty list removes the restriction
+func SetGoalGear(athleteID int, year int, gearIDs []string) error {
+	data, err := json.Marshal(gearIDs)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		goalGearBucket, err := bucket.CreateBucketIfNotExists([]byte("goalGear"))
+		if err != nil {
+			return err
+		}
+		return goalGearBucket.Put([]byte(strconv.Itoa(year)), data)
 	})
 	return err
 }
 
-func GetGoal(athleteID int) (float64, error) {
-	var goal float64
+// GetGoalGear returns IDs of the bikes which count towards the goal of the
+// year. Empty list means that all bikes count
+func GetGoalGear(athleteID int, year int) ([]string, error) {
+	var gearIDs []string
 	err := DB.View(func(tx *bolt.Tx) error {
-		var err error
 		authBucket := tx.Bucket(AccountBucket)
 		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
 		if bucket == nil {
 			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
 		}
-		goalBytes := bucket.Get([]byte("goal"))
-		if goalBytes == nil {
-			return fmt.Errorf("goal for athleteID %d is not found", athleteID)
+		goalGearBucket := bucket.Bucket([]byte("goalGear"))
+		if goalGearBucket == nil {
+			return nil
+		}
+		data := goalGearBucket.Get([]byte(strconv.Itoa(year)))
+		if data == nil {
+			return nil
+		}
+		return json.Unmarshal(data, &gearIDs)
+	})
+	return gearIDs, err
+}
+
+// GetGoals returns all goals of the athlete in meters, with year as a key
+func GetGoals(athleteID int) (map[int]float64, error) {
+	goals := map[int]float64{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+		goalsBucket := bucket.Bucket([]byte("goals"))
+		if goalsBucket == nil {
+			return nil
+		}
+		return goalsBucket.ForEach(func(k, v []byte) error {
+			year, err := strconv.Atoi(string(k))
+			if err != nil {
+				return err
+			}
+			goal, err := strconv.ParseFloat(string(v), 64)
+			if err != nil {
+				return err
+			}
+			goals[year] = goal
+			return nil
+		})
+	})
+	return goals, err
+}
+
+// MigrateLegacyGoals moves goals which were stored without a year into the
+// goals of the current year
+func MigrateLegacyGoals() error {
+	year := []byte(strconv.Itoa(time.Now().Year()))
+	err := DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+		return authBucket.ForEach(func(k, v []byte) error {
+			bucket := authBucket.Bucket(k)
+			if bucket == nil {
+				return nil
+			}
+			goal := bucket.Get([]byte("goal"))
+			if goal == nil {
+				return nil
+			}
+			goalsBucket, err := bucket.CreateBucketIfNotExists([]byte("goals"))
+			if err != nil {
+				return err
+			}
+			if goalsBucket.Get(year) == nil {
+				err = goalsBucket.Put(year, goal)
+				if err != nil {
+					return err
+				}
+			}
+			Logger.Printf("migrated goal of athlete %s to %s\n", k, year)
+			return bucket.Delete([]byte("goal"))
+		})
+	})
+	return err
+}
+
+const (
+	// RolloverOff means that goal must be set manually every year
+	RolloverOff = "off"
+	// RolloverCopy copies goal of the last year
+	RolloverCopy = "copy"
+	// RolloverIncrease increases goal of the last year by a percentage
+	RolloverIncrease = "increase"
+)
+
+// RolloverSettings defines how the goal is created for a new year
+type RolloverSettings struct {
+	Mode    string  `json:"mode"`
+	Percent float64 `json:"percent"`
+}
+
+func SetRolloverSettings(athleteID int, settings *RolloverSettings) error {
+	return setAthleteValue(athleteID, "rollover", settings)
+}
+
+// GetRolloverSettings returns rollover settings of the athlete. Last year's
+// goal is copied by default
+func GetRolloverSettings(athleteID int) (*RolloverSettings, error) {
+	settings := &RolloverSettings{Mode: RolloverCopy}
+	_, err := getAthleteValue(athleteID, "rollover", settings)
+	return settings, err
+}
+
+// SetAthleteName saves the display name of the athlete
+func SetAthleteName(athleteID int, name string) error {
+	return setAthleteValue(athleteID, "name", name)
+}
+
+// GetAthleteName returns the display name of the athlete. Falls back to the
+// athlete ID if the name is unknown
+func GetAthleteName(athleteID int) string {
+	var name string
+	found, err := getAthleteValue(athleteID, "name", &name)
+	if err != nil || !found || name == "" {
+		return fmt.Sprintf("athlete %d", athleteID)
+	}
+	return name
+}
+
+// ProfileSettings controls if the public profile page of the athlete is
+// available, which metrics are visible there and if the athlete is listed on
+// the leaderboards
+type ProfileSettings struct {
+	Public          bool `json:"public"`
+	ShowGoal        bool `json:"show_goal"`
+	ShowMonthly     bool `json:"show_monthly"`
+	ShowLongestRide bool `json:"show_longest_ride"`
+	Leaderboard     bool `json:"leaderboard"`
+}
+
+func SetProfileSettings(athleteID int, settings *ProfileSettings) error {
+	return setAthleteValue(athleteID, "profile", settings)
+}
+
+// GetProfileSettings returns profile settings of the athlete. The profile is
+// private by default
+func GetProfileSettings(athleteID int) (*ProfileSettings, error) {
+	settings := &ProfileSettings{}
+	_, err := getAthleteValue(athleteID, "profile", settings)
+	return settings, err
+}
+
+// DescriptionSettings contains optional lines which athlete wants to see in
+// the activity description
+type DescriptionSettings struct {
+	YearOverYear bool `json:"year_over_year"`
+	Streaks      bool `json:"streaks"`
+	Eddington    bool `json:"eddington"`
+	Maintenance  bool `json:"maintenance"`
+	Teams        bool `json:"teams"`
+	Challenges   bool `json:"challenges"`
+	// Preview stores rendered descriptions in the outbox instead of updating
+	// activities
+	Preview bool `json:"preview"`
+}
+
+func SetDescriptionSettings(athleteID int, settings *DescriptionSettings) error {
+	return setAthleteValue(athleteID, "description", settings)
+}
+
+// GetDescriptionSettings returns description settings of the athlete. All
+// optional lines are disabled by default
+func GetDescriptionSettings(athleteID int) (*DescriptionSettings, error) {
+	settings := &DescriptionSettings{}
+	_, err := getAthleteValue(athleteID, "description", settings)
+	return settings, err
+}
+
+// EmailSettings contains the address and email notifications the athlete
+// opted in to
+// Notes:
+//   - `UnsubscribeToken` identifies the athlete in unsubscribe links
+type EmailSettings struct {
+	Address          string `json:"address"`
+	Milestones       bool   `json:"milestones"`
+	Digest           bool   `json:"digest"`
+	Behind           bool   `json:"behind"`
+	UnsubscribeToken string `json:"unsubscribe_token"`
+}
+
+// SetEmailSettings saves email settings of the athlete and keeps the index of
+// unsubscribe tokens up to date
+func SetEmailSettings(athleteID int, settings *EmailSettings) error {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		tokenBucket := tx.Bucket(UnsubscribeBucket)
+		previous := &EmailSettings{}
+		if current := bucket.Get([]byte("email")); current != nil {
+			err := json.Unmarshal(current, previous)
+			if err != nil {
+				return err
+			}
+		}
+		if previous.UnsubscribeToken != "" && previous.UnsubscribeToken != settings.UnsubscribeToken {
+			err := tokenBucket.Delete([]byte(previous.UnsubscribeToken))
+			if err != nil {
+				return err
+			}
+		}
+		if settings.UnsubscribeToken != "" {
+			err := tokenBucket.Put([]byte(settings.UnsubscribeToken), []byte(strconv.Itoa(athleteID)))
+			if err != nil {
+				return err
+			}
+		}
+		return bucket.Put([]byte("email"), data)
+	})
+	return err
+}
+
+// GetAthleteByUnsubscribeToken returns ID of the athlete with the unsubscribe
+// token
+func GetAthleteByUnsubscribeToken(token string) (int, error) {
+	athleteID := 0
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(UnsubscribeBucket).Get([]byte(token))
+		if token == "" || data == nil {
+			return fmt.Errorf("unsubscribe token doesn't exist")
 		}
-		goalStr := string(goalBytes)
-		goal, err = strconv.ParseFloat(goalStr, 64)
+		var err error
+		athleteID, err = strconv.Atoi(string(data))
 		return err
 	})
-	return goal, err
+	return athleteID, err
+}
+
+// IndexUnsubscribeTokens adds unsubscribe tokens which were saved before the
+// index existed to the index
+func IndexUnsubscribeTokens() error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+		tokenBucket := tx.Bucket(UnsubscribeBucket)
+		return authBucket.ForEach(func(k, v []byte) error {
+			bucket := authBucket.Bucket(k)
+			if bucket == nil {
+				return nil
+			}
+			data := bucket.Get([]byte("email"))
+			if data == nil {
+				return nil
+			}
+			settings := &EmailSettings{}
+			err := json.Unmarshal(data, settings)
+			if err != nil {
+				return err
+			}
+			if settings.UnsubscribeToken == "" || tokenBucket.Get([]byte(settings.UnsubscribeToken)) != nil {
+				return nil
+			}
+			return tokenBucket.Put([]byte(settings.UnsubscribeToken), k)
+		})
+	})
+	return err
+}
+
+// GetEmailSettings returns email settings of the athlete. All notifications
+// are disabled by default
+func GetEmailSettings(athleteID int) (*EmailSettings, error) {
+	settings := &EmailSettings{}
+	_, err := getAthleteValue(athleteID, "email", settings)
+	return settings, err
+}
+
+// PushSettings contains the ntfy or Gotify endpoint of the athlete
+// Notes:
+//   - `URL` is the topic URL for ntfy and the server URL for Gotify
+//   - `Token` is the access token for ntfy or the application token for Gotify
+type PushSettings struct {
+	Service    string `json:"service"`
+	URL        string `json:"url"`
+	Token      string `json:"token"`
+	Milestones bool   `json:"milestones"`
+	Behind     bool   `json:"behind"`
+}
+
+func SetPushSettings(athleteID int, settings *PushSettings) error {
+	return setAthleteValue(athleteID, "push", settings)
+}
+
+// GetPushSettings returns push notification settings of the athlete. Push
+// notifications are disabled by default
+func GetPushSettings(athleteID int) (*PushSettings, error) {
+	settings := &PushSettings{}
+	_, err := getAthleteValue(athleteID, "push", settings)
+	return settings, err
+}
+
+// NudgeSettings defines when the athlete gets "falling behind" nudges
+// Notes:
+//   - `Threshold` is in meters behind the schedule
+//   - `LastNudge` is used to send at most one nudge per `NudgeInterval`
+type NudgeSettings struct {
+	Threshold    float64   `json:"threshold"`
+	InactiveDays int       `json:"inactive_days"`
+	LastNudge    time.Time `json:"last_nudge"`
+}
+
+func SetNudgeSettings(athleteID int, settings *NudgeSettings) error {
+	return setAthleteValue(athleteID, "nudge", settings)
+}
+
+// GetNudgeSettings returns nudge settings of the athlete. Athletes are nudged
+// when they are 100 km behind or haven't ridden for 7 days by default
+func GetNudgeSettings(athleteID int) (*NudgeSettings, error) {
+	settings := &NudgeSettings{Threshold: 100000, InactiveDays: 7}
+	_, err := getAthleteValue(athleteID, "nudge", settings)
+	return settings, err
+}
+
+// SetBikes saves bikes of the athlete retrieved from Strava
+func SetBikes(athleteID int, bikes []Gear) error {
+	return setAthleteValue(athleteID, "bikes", bikes)
+}
+
+func GetBikes(athleteID int) ([]Gear, error) {
+	var bikes []Gear
+	_, err := getAthleteValue(athleteID, "bikes", &bikes)
+	return bikes, err
+}
+
+func SetMaintenanceReminders(athleteID int, reminders []MaintenanceReminder) error {
+	return setAthleteValue(athleteID, "maintenance", reminders)
+}
+
+func GetMaintenanceReminders(athleteID int) ([]MaintenanceReminder, error) {
+	var reminders []MaintenanceReminder
+	_, err := getAthleteValue(athleteID, "maintenance", &reminders)
+	return reminders, err
+}
+
+// SetRideHistory saves distances of rides of the athlete before the current
+// year
+func SetRideHistory(athleteID int, history *RideHistory) error {
+	return setAthleteValue(athleteID, "rideHistory", history)
+}
+
+// GetRideHistory returns distances of rides of the athlete before the current
+// year. Returns empty history if it was never fetched
+func GetRideHistory(athleteID int) (*RideHistory, error) {
+	history := &RideHistory{}
+	_, err := getAthleteValue(athleteID, "rideHistory", history)
+	return history, err
+}
+
+// SaveActivities stores cycling activities of the athlete. Existing
+// activities with the same ID are overwritten
+func SaveActivities(athleteID int, activities []Activity) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		activityBucket := tx.Bucket(ActivityBucket)
+
+		athleteBucket, err := activityBucket.CreateBucketIfNotExists([]byte(fmt.Sprintf("%d", athleteID)))
+		if err != nil {
+			return err
+		}
+
+		for _, activity := range activities {
+			data, err := json.Marshal(activity)
+			if err != nil {
+				return err
+			}
+			err = athleteBucket.Put([]byte(fmt.Sprintf("%d", activity.ID)), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// GetActivities returns all stored activities of the athlete sorted by start date
+func GetActivities(athleteID int) ([]Activity, error) {
+	var activities []Activity
+	err := DB.View(func(tx *bolt.Tx) error {
+		activityBucket := tx.Bucket(ActivityBucket)
+
+		athleteBucket := activityBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if athleteBucket == nil {
+			return nil
+		}
+
+		return athleteBucket.ForEach(func(k, v []byte) error {
+			var activity Activity
+			err := json.Unmarshal(v, &activity)
+			if err != nil {
+				return err
+			}
+			activities = append(activities, activity)
+			return nil
+		})
+	})
+	sort.Slice(activities, func(i, j int) bool {
+		return activities[i].StartDate.Before(activities[j].StartDate)
+	})
+	return activities, err
+}
+
+// GetAthleteIDs returns IDs of all registered athletes
+func GetAthleteIDs() ([]int, error) {
+	var ids []int
+	err := DB.View(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+		return authBucket.ForEach(func(k, v []byte) error {
+			id, err := strconv.Atoi(string(k))
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return nil
+		})
+	})
+	return ids, err
+}
+
+// DeleteAthlete removes the athlete with all activities, summaries, audit log
+// and API tokens. The athlete leaves all teams and challenges, teams without
+// members are deleted
+func DeleteAthlete(athleteID int) error {
+	key := []byte(fmt.Sprintf("%d", athleteID))
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket(key)
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+		if data := bucket.Get([]byte("email")); data != nil {
+			settings := &EmailSettings{}
+			err := json.Unmarshal(data, settings)
+			if err != nil {
+				return err
+			}
+			if settings.UnsubscribeToken != "" {
+				err = tx.Bucket(UnsubscribeBucket).Delete([]byte(settings.UnsubscribeToken))
+				if err != nil {
+					return err
+				}
+			}
+		}
+		err := tx.Bucket(AccountBucket).DeleteBucket(key)
+		if err != nil {
+			return err
+		}
+		for _, name := range [][]byte{ActivityBucket, SummaryBucket, AuditBucket} {
+			err = tx.Bucket(name).DeleteBucket(key)
+			if err != nil && err != bolt.ErrBucketNotFound {
+				return err
+			}
+		}
+
+		tokenBucket := tx.Bucket(APITokenBucket)
+		var hashes [][]byte
+		err = tokenBucket.ForEach(func(k, v []byte) error {
+			token := &APIToken{}
+			err := json.Unmarshal(v, token)
+			if err != nil {
+				return err
+			}
+			if token.AthleteID == athleteID {
+				hashes = append(hashes, k)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			err = tokenBucket.Delete(hash)
+			if err != nil {
+				return err
+			}
+		}
+
+		teams := map[string]*Team{}
+		err = tx.Bucket(TeamBucket).ForEach(func(k, v []byte) error {
+			team := &Team{}
+			err := json.Unmarshal(v, team)
+			if err != nil {
+				return err
+			}
+			teams[string(k)] = team
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for id, team := range teams {
+			members := []int{}
+			for _, member := range team.Members {
+				if member != athleteID {
+					members = append(members, member)
+				}
+			}
+			if len(members) == len(team.Members) {
+				continue
+			}
+			if len(members) == 0 {
+				err = tx.Bucket(TeamBucket).Delete([]byte(id))
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			team.Members = members
+			data, err := json.Marshal(team)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(TeamBucket).Put([]byte(id), data)
+			if err != nil {
+				return err
+			}
+		}
+
+		challenges := map[string]*Challenge{}
+		err = tx.Bucket(ChallengeBucket).ForEach(func(k, v []byte) error {
+			challenge := &Challenge{}
+			err := json.Unmarshal(v, challenge)
+			if err != nil {
+				return err
+			}
+			challenges[string(k)] = challenge
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for id, challenge := range challenges {
+			participants := []int{}
+			for _, participant := range challenge.Participants {
+				if participant != athleteID {
+					participants = append(participants, participant)
+				}
+			}
+			if len(participants) == len(challenge.Participants) {
+				continue
+			}
+			challenge.Participants = participants
+			data, err := json.Marshal(challenge)
+			if err != nil {
+				return err
+			}
+			err = tx.Bucket(ChallengeBucket).Put([]byte(id), data)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// SaveYearRecap stores the year-end summary of the athlete
+func SaveYearRecap(athleteID int, recap *YearRecap) error {
+	data, err := json.Marshal(recap)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		summaryBucket := tx.Bucket(SummaryBucket)
+
+		athleteBucket, err := summaryBucket.CreateBucketIfNotExists([]byte(fmt.Sprintf("%d", athleteID)))
+		if err != nil {
+			return err
+		}
+		return athleteBucket.Put([]byte(strconv.Itoa(recap.Year)), data)
+	})
+	return err
+}
+
+// GetYearRecap returns the year-end summary of the athlete. Returns nil if
+// the summary doesn't exist
+func GetYearRecap(athleteID int, year int) (*YearRecap, error) {
+	var recap *YearRecap
+	err := DB.View(func(tx *bolt.Tx) error {
+		summaryBucket := tx.Bucket(SummaryBucket)
+
+		athleteBucket := summaryBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if athleteBucket == nil {
+			return nil
+		}
+		data := athleteBucket.Get([]byte(strconv.Itoa(year)))
+		if data == nil {
+			return nil
+		}
+		recap = &YearRecap{}
+		return json.Unmarshal(data, recap)
+	})
+	return recap, err
+}
+
+// SaveTeam creates or updates the team
+func SaveTeam(team *Team) error {
+	data, err := json.Marshal(team)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(TeamBucket).Put([]byte(team.ID), data)
+	})
+	return err
+}
+
+func GetTeam(teamID string) (*Team, error) {
+	team := &Team{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(TeamBucket).Get([]byte(teamID))
+		if data == nil {
+			return fmt.Errorf("team %s doesn't exist", teamID)
+		}
+		return json.Unmarshal(data, team)
+	})
+	return team, err
+}
+
+// GetTeams returns all teams
+func GetTeams() ([]*Team, error) {
+	var teams []*Team
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(TeamBucket).ForEach(func(k, v []byte) error {
+			team := &Team{}
+			err := json.Unmarshal(v, team)
+			if err != nil {
+				return err
+			}
+			teams = append(teams, team)
+			return nil
+		})
+	})
+	return teams, err
+}
+
+func GetTeamByInviteCode(code string) (*Team, error) {
+	teams, err := GetTeams()
+	if err != nil {
+		return nil, err
+	}
+	for _, team := range teams {
+		if code != "" && team.InviteCode == code {
+			return team, nil
+		}
+	}
+	return nil, fmt.Errorf("team with invite code %s doesn't exist", code)
+}
+
+func DeleteTeam(teamID string) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(TeamBucket).Delete([]byte(teamID))
+	})
+	return err
+}
+
+// UpdateTeam changes the team with `update` in one transaction, so concurrent
+// changes of members are not lost. The team is deleted if it has no members
+// left
+func UpdateTeam(teamID string, update func(team *Team) error) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(TeamBucket)
+		data := bucket.Get([]byte(teamID))
+		if data == nil {
+			return fmt.Errorf("team %s doesn't exist", teamID)
+		}
+		team := &Team{}
+		err := json.Unmarshal(data, team)
+		if err != nil {
+			return err
+		}
+		err = update(team)
+		if err != nil {
+			return err
+		}
+		if len(team.Members) == 0 {
+			return bucket.Delete([]byte(teamID))
+		}
+		data, err = json.Marshal(team)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(teamID), data)
+	})
+	return err
+}
+
+// SaveChallenge creates or updates the challenge
+func SaveChallenge(challenge *Challenge) error {
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ChallengeBucket).Put([]byte(challenge.ID), data)
+	})
+	return err
+}
+
+// UpdateChallenge changes the challenge with `update` in one transaction, so
+// concurrent changes of participants are not lost
+func UpdateChallenge(challengeID string, update func(challenge *Challenge) error) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(ChallengeBucket)
+		data := bucket.Get([]byte(challengeID))
+		if data == nil {
+			return fmt.Errorf("challenge %s doesn't exist", challengeID)
+		}
+		challenge := &Challenge{}
+		err := json.Unmarshal(data, challenge)
+		if err != nil {
+			return err
+		}
+		err = update(challenge)
+		if err != nil {
+			return err
+		}
+		data, err = json.Marshal(challenge)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(challengeID), data)
+	})
+	return err
+}
+
+func GetChallenge(challengeID string) (*Challenge, error) {
+	challenge := &Challenge{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(ChallengeBucket).Get([]byte(challengeID))
+		if data == nil {
+			return fmt.Errorf("challenge %s doesn't exist", challengeID)
+		}
+		return json.Unmarshal(data, challenge)
+	})
+	return challenge, err
+}
+
+// GetChallenges returns all challenges
+func GetChallenges() ([]*Challenge, error) {
+	var challenges []*Challenge
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(ChallengeBucket).ForEach(func(k, v []byte) error {
+			challenge := &Challenge{}
+			err := json.Unmarshal(v, challenge)
+			if err != nil {
+				return err
+			}
+			challenges = append(challenges, challenge)
+			return nil
+		})
+	})
+	return challenges, err
+}
+
+func GetChallengeByInviteCode(code string) (*Challenge, error) {
+	challenges, err := GetChallenges()
+	if err != nil {
+		return nil, err
+	}
+	for _, challenge := range challenges {
+		if code != "" && challenge.InviteCode == code {
+			return challenge, nil
+		}
+	}
+	return nil, fmt.Errorf("challenge with invite code %s doesn't exist", code)
+}
+
+func SaveAPIToken(token *APIToken) error {
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(APITokenBucket).Put([]byte(token.Hash), data)
+	})
+	return err
+}
+
+// GetAPIToken returns information about the token by its hash
+func GetAPIToken(hash string) (*APIToken, error) {
+	token := &APIToken{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(APITokenBucket).Get([]byte(hash))
+		if data == nil {
+			return fmt.Errorf("API token doesn't exist")
+		}
+		return json.Unmarshal(data, token)
+	})
+	return token, err
+}
+
+// GetAthleteAPITokens returns all API tokens of the athlete
+func GetAthleteAPITokens(athleteID int) ([]*APIToken, error) {
+	var tokens []*APIToken
+	err := DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(APITokenBucket).ForEach(func(k, v []byte) error {
+			token := &APIToken{}
+			err := json.Unmarshal(v, token)
+			if err != nil {
+				return err
+			}
+			if token.AthleteID == athleteID {
+				tokens = append(tokens, token)
+			}
+			return nil
+		})
+	})
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+	})
+	return tokens, err
+}
+
+// DeleteAPIToken revokes the API token of the athlete
+func DeleteAPIToken(athleteID int, hash string) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(APITokenBucket)
+		data := bucket.Get([]byte(hash))
+		if data == nil {
+			return nil
+		}
+		token := &APIToken{}
+		err := json.Unmarshal(data, token)
+		if err != nil {
+			return err
+		}
+		if token.AthleteID != athleteID {
+			return fmt.Errorf("API token doesn't belong to athleteID %d", athleteID)
+		}
+		return bucket.Delete([]byte(hash))
+	})
+	return err
+}
+
+// setAthleteValue stores JSON encoded value under the key in the athlete's bucket
+func setAthleteValue(athleteID int, key string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		return bucket.Put([]byte(key), data)
+	})
+	return err
+}
+
+// getAthleteValue decodes JSON encoded value stored under the key in the
+// athlete's bucket. Returns false if the value is not set
+func getAthleteValue(athleteID int, key string, value interface{}) (bool, error) {
+	var found bool
+	err := DB.View(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		data := bucket.Get([]byte(key))
+		if data == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(data, value)
+	})
+	return found, err
+}
+
+// SetWebhooks saves outgoing webhooks of the athlete
+func SetWebhooks(athleteID int, webhooks []Webhook) error {
+	return setAthleteValue(athleteID, "webhooks", webhooks)
+}
+
+// GetWebhooks returns outgoing webhooks of the athlete
+func GetWebhooks(athleteID int) ([]Webhook, error) {
+	var webhooks []Webhook
+	_, err := getAthleteValue(athleteID, "webhooks", &webhooks)
+	return webhooks, err
+}
+
+// AddWebhookDelivery appends the delivery to the log of the athlete. Only the
+// latest `WebhookDeliveryLogSize` deliveries are kept
+func AddWebhookDelivery(athleteID int, delivery *WebhookDelivery) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		deliveries := []WebhookDelivery{}
+		data := bucket.Get([]byte("webhookDeliveries"))
+		if data != nil {
+			err := json.Unmarshal(data, &deliveries)
+			if err != nil {
+				return err
+			}
+		}
+		deliveries = append([]WebhookDelivery{*delivery}, deliveries...)
+		if len(deliveries) > WebhookDeliveryLogSize {
+			deliveries = deliveries[:WebhookDeliveryLogSize]
+		}
+
+		data, err := json.Marshal(deliveries)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("webhookDeliveries"), data)
+	})
+	return err
+}
+
+// GetWebhookDeliveries returns the delivery log of the athlete, newest first
+func GetWebhookDeliveries(athleteID int) ([]WebhookDelivery, error) {
+	var deliveries []WebhookDelivery
+	_, err := getAthleteValue(athleteID, "webhookDeliveries", &deliveries)
+	return deliveries, err
+}
+
+// SetActivityAnnotated records that the description of the activity was
+// updated
+func SetActivityAnnotated(athleteID int, activityID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		annotatedBucket, err := bucket.CreateBucketIfNotExists([]byte("annotated"))
+		if err != nil {
+			return err
+		}
+		return annotatedBucket.Put([]byte(fmt.Sprintf("%d", activityID)), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	return err
+}
+
+// IsActivityAnnotated reports whether the description of the activity was
+// updated
+func IsActivityAnnotated(athleteID int, activityID int) (bool, error) {
+	annotated := false
+	err := DB.View(func(tx *bolt.Tx) error {
+		authBucket := tx.Bucket(AccountBucket)
+
+		bucket := authBucket.Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		annotatedBucket := bucket.Bucket([]byte("annotated"))
+		if annotatedBucket == nil {
+			return nil
+		}
+		annotated = annotatedBucket.Get([]byte(fmt.Sprintf("%d", activityID))) != nil
+		return nil
+	})
+	return annotated, err
+}
+
+// GetAnnotatedActivities returns IDs of all annotated activities of the
+// athlete
+func GetAnnotatedActivities(athleteID int) ([]int, error) {
+	activityIDs := []int{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		annotatedBucket := bucket.Bucket([]byte("annotated"))
+		if annotatedBucket == nil {
+			return nil
+		}
+		return annotatedBucket.ForEach(func(k, v []byte) error {
+			activityID, err := strconv.Atoi(string(k))
+			if err != nil {
+				return err
+			}
+			activityIDs = append(activityIDs, activityID)
+			return nil
+		})
+	})
+	return activityIDs, err
+}
+
+// SetOriginalDescription saves the description the activity had before the
+// annotation. The first saved description is kept
+func SetOriginalDescription(athleteID int, activityID int, description string) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		originalsBucket, err := bucket.CreateBucketIfNotExists([]byte("originals"))
+		if err != nil {
+			return err
+		}
+		key := []byte(fmt.Sprintf("%d", activityID))
+		if originalsBucket.Get(key) != nil {
+			return nil
+		}
+		return originalsBucket.Put(key, []byte(description))
+	})
+	return err
+}
+
+// GetOriginalDescription returns the description the activity had before the
+// annotation. Returns false if it is unknown, e.g. for activities annotated
+// before originals were stored
+func GetOriginalDescription(athleteID int, activityID int) (string, bool, error) {
+	description := ""
+	found := false
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		originalsBucket := bucket.Bucket([]byte("originals"))
+		if originalsBucket == nil {
+			return nil
+		}
+		data := originalsBucket.Get([]byte(fmt.Sprintf("%d", activityID)))
+		if data == nil {
+			return nil
+		}
+		description = string(data)
+		found = true
+		return nil
+	})
+	return description, found, err
+}
+
+// SetActivityReverted records that the annotation of the activity was undone,
+// so the activity is never annotated again
+func SetActivityReverted(athleteID int, activityID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		revertedBucket, err := bucket.CreateBucketIfNotExists([]byte("reverted"))
+		if err != nil {
+			return err
+		}
+		return revertedBucket.Put([]byte(fmt.Sprintf("%d", activityID)), []byte(time.Now().UTC().Format(time.RFC3339)))
+	})
+	return err
+}
+
+// DeleteActivityReverted allows the activity to be annotated again
+func DeleteActivityReverted(athleteID int, activityID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		revertedBucket := bucket.Bucket([]byte("reverted"))
+		if revertedBucket == nil {
+			return nil
+		}
+		return revertedBucket.Delete([]byte(fmt.Sprintf("%d", activityID)))
+	})
+	return err
+}
+
+// AddActivityFailure records the failed attempt to annotate the activity.
+// Returns the number of failed attempts
+func AddActivityFailure(athleteID int, activityID int) (int, error) {
+	attempts := 0
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		failuresBucket, err := bucket.CreateBucketIfNotExists([]byte("failures"))
+		if err != nil {
+			return err
+		}
+		key := []byte(fmt.Sprintf("%d", activityID))
+		if data := failuresBucket.Get(key); data != nil {
+			attempts, err = strconv.Atoi(string(data))
+			if err != nil {
+				return err
+			}
+		}
+		attempts++
+		return failuresBucket.Put(key, []byte(strconv.Itoa(attempts)))
+	})
+	return attempts, err
+}
+
+// GetActivityFailures returns the number of failed attempts to annotate the
+// activity
+func GetActivityFailures(athleteID int, activityID int) (int, error) {
+	attempts := 0
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		failuresBucket := bucket.Bucket([]byte("failures"))
+		if failuresBucket == nil {
+			return nil
+		}
+		data := failuresBucket.Get([]byte(fmt.Sprintf("%d", activityID)))
+		if data == nil {
+			return nil
+		}
+		var err error
+		attempts, err = strconv.Atoi(string(data))
+		return err
+	})
+	return attempts, err
+}
+
+// DeleteActivityFailures forgets failed attempts to annotate the activity
+func DeleteActivityFailures(athleteID int, activityID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		failuresBucket := bucket.Bucket([]byte("failures"))
+		if failuresBucket == nil {
+			return nil
+		}
+		return failuresBucket.Delete([]byte(fmt.Sprintf("%d", activityID)))
+	})
+	return err
+}
+
+// IsActivityReverted reports whether the annotation of the activity was undone
+// or discarded in the preview
+func IsActivityReverted(athleteID int, activityID int) (bool, error) {
+	reverted := false
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		revertedBucket := bucket.Bucket([]byte("reverted"))
+		if revertedBucket == nil {
+			return nil
+		}
+		reverted = revertedBucket.Get([]byte(fmt.Sprintf("%d", activityID))) != nil
+		return nil
+	})
+	return reverted, err
+}
+
+// SetOutboxEntry saves the description waiting for approval. The previous
+// entry of the same activity is replaced
+func SetOutboxEntry(athleteID int, entry *OutboxEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		outboxBucket, err := bucket.CreateBucketIfNotExists([]byte("outbox"))
+		if err != nil {
+			return err
+		}
+		return outboxBucket.Put([]byte(fmt.Sprintf("%d", entry.ActivityID)), data)
+	})
+	return err
+}
+
+// GetOutboxEntry returns the description of the activity waiting for approval.
+// Returns nil if there is none
+func GetOutboxEntry(athleteID int, activityID int) (*OutboxEntry, error) {
+	var entry *OutboxEntry
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		outboxBucket := bucket.Bucket([]byte("outbox"))
+		if outboxBucket == nil {
+			return nil
+		}
+		data := outboxBucket.Get([]byte(fmt.Sprintf("%d", activityID)))
+		if data == nil {
+			return nil
+		}
+		entry = &OutboxEntry{}
+		return json.Unmarshal(data, entry)
+	})
+	return entry, err
+}
+
+// GetOutbox returns all descriptions of the athlete waiting for approval,
+// newest activities first
+func GetOutbox(athleteID int) ([]OutboxEntry, error) {
+	entries := []OutboxEntry{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		outboxBucket := bucket.Bucket([]byte("outbox"))
+		if outboxBucket == nil {
+			return nil
+		}
+		return outboxBucket.ForEach(func(k, v []byte) error {
+			entry := OutboxEntry{}
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+			return nil
+		})
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].StartDate.After(entries[j].StartDate)
+	})
+	return entries, err
+}
+
+// DeleteOutboxEntry removes the description of the activity from the outbox
+func DeleteOutboxEntry(athleteID int, activityID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(AccountBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if bucket == nil {
+			return fmt.Errorf("user with athleteID %d doesn't exist", athleteID)
+		}
+
+		outboxBucket := bucket.Bucket([]byte("outbox"))
+		if outboxBucket == nil {
+			return nil
+		}
+		return outboxBucket.Delete([]byte(fmt.Sprintf("%d", activityID)))
+	})
+	return err
+}
+
+// AddAuditEntry appends the entry to the audit log of the athlete
+func AddAuditEntry(athleteID int, entry *AuditEntry) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		athleteBucket, err := tx.Bucket(AuditBucket).CreateBucketIfNotExists([]byte(fmt.Sprintf("%d", athleteID)))
+		if err != nil {
+			return err
+		}
+		id, err := athleteBucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		// Zero padded keys keep entries in the order they were added
+		return athleteBucket.Put([]byte(fmt.Sprintf("%020d", id)), data)
+	})
+	return err
+}
+
+// GetAuditLog returns up to `limit` latest audit entries of the athlete,
+// newest first. Returns all entries if `limit` is 0
+func GetAuditLog(athleteID int, limit int) ([]AuditEntry, error) {
+	entries := []AuditEntry{}
+	err := DB.View(func(tx *bolt.Tx) error {
+		athleteBucket := tx.Bucket(AuditBucket).Bucket([]byte(fmt.Sprintf("%d", athleteID)))
+		if athleteBucket == nil {
+			return nil
+		}
+		c := athleteBucket.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(entries) >= limit {
+				break
+			}
+			entry := AuditEntry{}
+			err := json.Unmarshal(v, &entry)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// SetSubscriptionID saves ID of the Strava push subscription
+func SetSubscriptionID(subscriptionID int) error {
+	err := DB.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(AppBucket).Put([]byte("subscriptionId"), []byte(strconv.Itoa(subscriptionID)))
+	})
+	return err
+}
+
+// GetSubscriptionID returns ID of the Strava push subscription. Returns 0 if
+// it is unknown
+func GetSubscriptionID() (int, error) {
+	subscriptionID := 0
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(AppBucket).Get([]byte("subscriptionId"))
+		if data == nil {
+			return nil
+		}
+		var err error
+		subscriptionID, err = strconv.Atoi(string(data))
+		return err
+	})
+	return subscriptionID, err
 }
@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// Digest is last week's summary sent on Mondays
+// Notes:
+//   - all distance is in meters
+//   - `RequiredPerWeek` is the distance per week needed to reach the goal
+//     by the end of the year
+type Digest struct {
+	WeekStart       time.Time
+	Distance        float64
+	Rides           int
+	YearDistance    float64
+	Goal            float64
+	Remaining       float64
+	WeeksLeft       float64
+	RequiredPerWeek float64
+}
+
+// computeDigest summarizes the previous week and the remaining pace towards
+// the goal. `now` must be in athlete's time zone
+func computeDigest(activities []Activity, goal float64, goalGear []string, now time.Time) *Digest {
+	today := localDay(now)
+	weekEnd := startOfWeek(today)
+	digest := &Digest{
+		WeekStart: weekEnd.AddDate(0, 0, -7),
+		Goal:      goal,
+	}
+	for _, activity := range activities {
+		day := localDay(activity.StartDateLocal)
+		if !day.Before(digest.WeekStart) && day.Before(weekEnd) {
+			digest.Distance += activity.Distance
+			digest.Rides++
+		}
+		if day.Year() == today.Year() && !day.After(today) && matchesGear(activity, goalGear) {
+			digest.YearDistance += activity.Distance
+		}
+	}
+	if goal > digest.YearDistance {
+		digest.Remaining = goal - digest.YearDistance
+	}
+	yearEnd := time.Date(today.Year()+1, time.January, 1, 0, 0, 0, 0, time.UTC)
+	digest.WeeksLeft = yearEnd.Sub(today).Hours() / 24 / 7
+	if digest.WeeksLeft > 0 {
+		digest.RequiredPerWeek = digest.Remaining / digest.WeeksLeft
+	}
+	return digest
+}
+
+// renderTextTemplate renders the text template of emails and notifications
+func renderTextTemplate(name string, data interface{}) (string, error) {
+	tmplContent, err := TemplatesStorage.ReadFile("templates/" + name)
+	if err != nil {
+		return "", err
+	}
+
+	funcMap := template.FuncMap{
+		"toKm": func(meters float64) string {
+			return fmt.Sprintf("%.2f", meters/1000)
+		},
+	}
+
+	tmpl, err := template.New(name).Funcs(funcMap).Parse(string(tmplContent))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// unsubscribeURL returns the link which disables the list of emails
+func unsubscribeURL(settings *EmailSettings, list string) string {
+	return fmt.Sprintf("https://%s/unsubscribe?token=%s&list=%s", rootDomain, settings.UnsubscribeToken, list)
+}
+
+// sendEmail sends the plain text email through the configured SMTP server.
+// Does nothing if SMTP isn't configured
+func sendEmail(to string, subject string, body string, unsubscribe string) error {
+	if rootSMTPHost == "" {
+		return nil
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", rootSMTPFrom)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	if unsubscribe != "" {
+		fmt.Fprintf(&msg, "List-Unsubscribe: <%s>\r\n", unsubscribe)
+		fmt.Fprintf(&msg, "List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n")
+	}
+	fmt.Fprintf(&msg, "\r\n%s", strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if rootSMTPUsername != "" {
+		auth = smtp.PlainAuth("", rootSMTPUsername, rootSMTPPassword, rootSMTPHost)
+	}
+	return smtp.SendMail(net.JoinHostPort(rootSMTPHost, rootSMTPPort), auth, rootSMTPFrom, []string{to}, msg.Bytes())
+}
+
+// weeklyDigestJob sends last week's digest to athletes who opted in
+func weeklyDigestJob() {
+	now := time.Now()
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for _, athleteID := range athleteIDs {
+		err = sendDigestEmail(athleteID, now)
+		if err != nil {
+			Logger.Printf("failed to send digest to athlete %d: %s\n", athleteID, err)
+		}
+	}
+}
+
+// sendDigestEmail sends last week's digest to the athlete if the athlete
+// opted in. Athletes without a goal get only the distance summary
+func sendDigestEmail(athleteID int, now time.Time) error {
+	settings, err := GetEmailSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	if !settings.Digest || settings.Address == "" {
+		return nil
+	}
+
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return err
+	}
+	goal, err := GetGoal(athleteID, now.Year())
+	if err != nil && !errors.Is(err, ErrGoalNotFound) {
+		return err
+	}
+	goalGear, err := GetGoalGear(athleteID, now.Year())
+	if err != nil {
+		return err
+	}
+	digest := computeDigest(activities, goal, goalGear, now.In(athleteLocation(activities)))
+
+	body, err := renderTextTemplate("email_digest.txt", map[string]interface{}{
+		"Digest":      digest,
+		"Unsubscribe": unsubscribeURL(settings, "digest"),
+	})
+	if err != nil {
+		return err
+	}
+	subject := fmt.Sprintf("Your week: %.2f km", digest.Distance/1000)
+	return sendEmail(settings.Address, subject, body, unsubscribeURL(settings, "digest"))
+}
+
+// updateEmailSettings saves the email address and notifications from the
+// submitted account form
+func updateEmailSettings(athleteID int, r *http.Request) error {
+	settings, err := GetEmailSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	address := strings.TrimSpace(r.FormValue("address"))
+	if address != "" {
+		parsed, err := mail.ParseAddress(address)
+		if err != nil {
+			return err
+		}
+		address = parsed.Address
+	}
+	settings.Address = address
+	settings.Milestones = r.FormValue("milestones") != ""
+	settings.Digest = r.FormValue("digest") != ""
+	settings.Behind = r.FormValue("behind") != ""
+	if settings.UnsubscribeToken == "" {
+		settings.UnsubscribeToken, err = GenerateRandomID(24)
+		if err != nil {
+			return err
+		}
+	}
+	return SetEmailSettings(athleteID, settings)
+}
+
+// unsubscribeHandler disables the list of emails using the link from the
+// email. GET requests only show the confirmation page, so link scanners
+// don't unsubscribe athletes. Supports one-click POST requests from mail
+// clients
+func unsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	token := r.URL.Query().Get("token")
+	list := r.URL.Query().Get("list")
+	athleteID, err := GetAthleteByUnsubscribeToken(token)
+	if err != nil {
+		http.Error(w, "Subscription not found", http.StatusNotFound)
+		return
+	}
+	if r.Method != http.MethodPost {
+		renderHTML(w, "templates/unsubscribe.html", map[string]string{
+			"Token": token,
+			"List":  list,
+		})
+		return
+	}
+
+	settings, err := GetEmailSettings(athleteID)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	switch list {
+	case "milestones":
+		settings.Milestones = false
+	case "digest":
+		settings.Digest = false
+	case "behind":
+		settings.Behind = false
+	default:
+		settings.Milestones = false
+		settings.Digest = false
+		settings.Behind = false
+	}
+	err = SetEmailSettings(athleteID, settings)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger.Printf("athlete %d unsubscribed from %s emails\n", athleteID, list)
+	renderHTML(w, "templates/unsubscribed.html", nil)
+}
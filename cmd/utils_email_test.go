@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startTestSMTPServer accepts emails on a local port and sends their data to
+// the returned channel
+func startTestSMTPServer(t *testing.T) <-chan string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	rootSMTPHost, rootSMTPPort, rootSMTPFrom = host, port, "go-cycle@localhost"
+	t.Cleanup(func() { rootSMTPHost = "" })
+
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			conn.Write([]byte("220 localhost ESMTP\r\n"))
+			var data strings.Builder
+			inData := false
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				if inData {
+					if line == ".\r\n" {
+						inData = false
+						messages <- data.String()
+						conn.Write([]byte("250 OK\r\n"))
+						continue
+					}
+					data.WriteString(line)
+					continue
+				}
+				switch strings.ToUpper(strings.Fields(line)[0]) {
+				case "EHLO", "HELO":
+					conn.Write([]byte("250 localhost\r\n"))
+				case "DATA":
+					inData = true
+					conn.Write([]byte("354 Go ahead\r\n"))
+				case "QUIT":
+					conn.Write([]byte("221 Bye\r\n"))
+					conn.Close()
+				default:
+					conn.Write([]byte("250 OK\r\n"))
+				}
+			}
+		}
+	}()
+	return messages
+}
+
+func Test_computeDigest(t *testing.T) {
+	now := time.Date(2023, time.October, 16, 9, 0, 0, 0, time.UTC) // Monday
+	activities := []Activity{
+		{Distance: 100000, StartDateLocal: time.Date(2023, time.January, 3, 8, 0, 0, 0, time.UTC)},
+		{Distance: 40000, StartDateLocal: time.Date(2023, time.October, 9, 8, 0, 0, 0, time.UTC)},
+		{Distance: 60000, StartDateLocal: time.Date(2023, time.October, 15, 8, 0, 0, 0, time.UTC), GearID: "b2"},
+		{Distance: 30000, StartDateLocal: time.Date(2023, time.October, 16, 7, 0, 0, 0, time.UTC)},
+	}
+
+	digest := computeDigest(activities, 1000000, []string{}, now)
+	if !digest.WeekStart.Equal(time.Date(2023, time.October, 9, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected week of October 9, got %s", digest.WeekStart)
+	}
+	if digest.Distance != 100000 || digest.Rides != 2 {
+		t.Errorf("expected 100 km in 2 rides, got %+v", digest)
+	}
+	if digest.YearDistance != 230000 || digest.Remaining != 770000 {
+		t.Errorf("expected 230 km ridden and 770 km left, got %+v", digest)
+	}
+	// 11 weeks until the end of the year
+	if digest.RequiredPerWeek < 70000 || digest.RequiredPerWeek > 71000 {
+		t.Errorf("expected ~70 km per week, got %f", digest.RequiredPerWeek)
+	}
+
+	digest = computeDigest(activities, 1000000, []string{"b2"}, now)
+	if digest.YearDistance != 60000 {
+		t.Errorf("expected only rides on b2 to count, got %+v", digest)
+	}
+}
+
+func Test_sendMilestoneNotification(t *testing.T) {
+	setupTestDB(t)
+	messages := startTestSMTPServer(t)
+	domain := rootDomain
+	rootDomain = "example.com"
+	t.Cleanup(func() { rootDomain = domain })
+
+	err := SetEmailSettings(1, &EmailSettings{Address: "rider@example.com", Milestones: true, UnsubscribeToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendMilestoneNotification(1, EventMilestoneCrossed, MilestoneEventData{ActivityID: 7, Milestone: 2000000, TotalDistance: 2010000})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-messages:
+		for _, expected := range []string{
+			"To: rider@example.com",
+			"Subject: You've ridden 2000 km this year",
+			"List-Unsubscribe: <https://example.com/unsubscribe?token=token&list=milestones>",
+			"crossed 2000.00 km",
+		} {
+			if !strings.Contains(message, expected) {
+				t.Errorf("expected %q in the message:\n%s", expected, message)
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("email wasn't sent")
+	}
+}
+
+func Test_sendDigestEmail_opted_out(t *testing.T) {
+	setupTestDB(t)
+	messages := startTestSMTPServer(t)
+
+	err := SetEmailSettings(1, &EmailSettings{Address: "rider@example.com", Milestones: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendDigestEmail(1, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 0 {
+		t.Errorf("expected no digest for the athlete who didn't opt in")
+	}
+}
+
+func Test_sendDigestEmail_without_goal(t *testing.T) {
+	setupTestDB(t)
+	messages := startTestSMTPServer(t)
+	now := time.Date(2023, time.October, 16, 9, 0, 0, 0, time.UTC)
+	err := SaveActivities(1, []Activity{{ID: 10, Distance: 40000, StartDateLocal: time.Date(2023, time.October, 9, 8, 0, 0, 0, time.UTC)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetEmailSettings(1, &EmailSettings{Address: "rider@example.com", Digest: true, UnsubscribeToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = sendDigestEmail(1, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case message := <-messages:
+		if !strings.Contains(message, "40.00 km this year.") || strings.Contains(message, "goal") {
+			t.Errorf("expected the digest without the goal:\n%s", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("digest wasn't sent")
+	}
+}
+
+func Test_unsubscribeHandler(t *testing.T) {
+	setupTestDB(t)
+	err := SetEmailSettings(1, &EmailSettings{Address: "rider@example.com", Milestones: true, Digest: true, UnsubscribeToken: "token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	unsubscribeHandler(w, httptest.NewRequest(http.MethodGet, "/unsubscribe?token=token&list=digest", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `method="POST"`) {
+		t.Fatalf("expected the confirmation page, got %d:\n%s", w.Code, w.Body.String())
+	}
+	settings, _ := GetEmailSettings(1)
+	if !settings.Digest {
+		t.Errorf("expected GET not to unsubscribe")
+	}
+
+	w = httptest.NewRecorder()
+	unsubscribeHandler(w, httptest.NewRequest(http.MethodPost, "/unsubscribe?token=token&list=digest", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	settings, _ = GetEmailSettings(1)
+	if settings.Digest || !settings.Milestones {
+		t.Errorf("expected only the digest to be disabled, got %+v", settings)
+	}
+
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		w = httptest.NewRecorder()
+		unsubscribeHandler(w, httptest.NewRequest(method, "/unsubscribe?token=wrong", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: expected 404 for unknown token, got %d", method, w.Code)
+		}
+	}
+}
+
+func Test_unsubscribe_token_index(t *testing.T) {
+	setupTestDB(t)
+	err := SetEmailSettings(1, &EmailSettings{UnsubscribeToken: "old"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetEmailSettings(1, &EmailSettings{UnsubscribeToken: "new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAthleteByUnsubscribeToken("old"); err == nil {
+		t.Errorf("expected the replaced token to be removed from the index")
+	}
+	if athleteID, err := GetAthleteByUnsubscribeToken("new"); err != nil || athleteID != 1 {
+		t.Errorf("expected the token of athlete 1, got %d %v", athleteID, err)
+	}
+
+	// Tokens saved before the index existed
+	err = SaveAuthData(2, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = setAthleteValue(2, "email", &EmailSettings{UnsubscribeToken: "legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = IndexUnsubscribeTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if athleteID, err := GetAthleteByUnsubscribeToken("legacy"); err != nil || athleteID != 2 {
+		t.Errorf("expected the legacy token to be indexed, got %d %v", athleteID, err)
+	}
+
+	err = DeleteAthlete(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAthleteByUnsubscribeToken("legacy"); err == nil {
+		t.Errorf("expected the token of the deleted athlete to be removed")
+	}
+}
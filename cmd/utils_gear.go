@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"golang.org/x/exp/slices"
+)
+
+// MaintenanceReminder is a maintenance interval of a bike component
+// Notes:
+//   - all distance is in meters
+//   - `ServicedAt` is the distance of the bike when the component was serviced
+type MaintenanceReminder struct {
+	ID         string  `json:"id"`
+	GearID     string  `json:"gear_id"`
+	Component  string  `json:"component"`
+	Interval   float64 `json:"interval"`
+	ServicedAt float64 `json:"serviced_at"`
+}
+
+// DueIn returns the distance left until the component needs service. The
+// distance is negative if service is overdue
+func (m *MaintenanceReminder) DueIn(gearDistance float64) float64 {
+	return m.ServicedAt + m.Interval - gearDistance
+}
+
+// BikeStats contains total distance of the bike and its maintenance status
+type BikeStats struct {
+	Gear
+	Rides     int
+	Reminders []MaintenanceDue
+}
+
+// MaintenanceDue is the current state of the maintenance reminder.
+// `Remaining` is the distance left until the component needs service
+type MaintenanceDue struct {
+	MaintenanceReminder
+	GearName  string
+	Remaining float64
+}
+
+// matchesGear reports whether the activity was done on one of the bikes. Empty
+// list of bikes matches all activities
+func matchesGear(activity Activity, gearIDs []string) bool {
+	return len(gearIDs) == 0 || slices.Contains(gearIDs, activity.GearID)
+}
+
+// gearDistances returns total distance and number of rides per gear ID
+func gearDistances(activities []Activity) (map[string]float64, map[string]int) {
+	distances := map[string]float64{}
+	rides := map[string]int{}
+	for _, activity := range activities {
+		if activity.GearID == "" {
+			continue
+		}
+		distances[activity.GearID] += activity.Distance
+		rides[activity.GearID]++
+	}
+	return distances, rides
+}
+
+// getBikeStats returns stats of all bikes of the athlete. Distance of the bike
+// is taken from Strava, or calculated from the stored activities if Strava
+// doesn't know it
+func getBikeStats(athleteID int) ([]*BikeStats, error) {
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	bikes, err := GetBikes(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	reminders, err := GetMaintenanceReminders(athleteID)
+	if err != nil {
+		return nil, err
+	}
+
+	distances, rides := gearDistances(activities)
+	gears := map[string]Gear{}
+	for _, bike := range bikes {
+		gears[bike.ID] = bike
+	}
+	// Bikes which were removed from Strava still have activities
+	for gearID := range distances {
+		if _, ok := gears[gearID]; !ok {
+			gears[gearID] = Gear{ID: gearID, Name: gearID}
+		}
+	}
+
+	stats := []*BikeStats{}
+	for gearID, gear := range gears {
+		// Maintenance reminders compare the same distance source every time
+		if gear.Distance == 0 {
+			gear.Distance = distances[gearID]
+		}
+		bike := &BikeStats{
+			Gear:  gear,
+			Rides: rides[gearID],
+		}
+		for _, reminder := range reminders {
+			if reminder.GearID == gearID {
+				bike.Reminders = append(bike.Reminders, MaintenanceDue{
+					MaintenanceReminder: reminder,
+					GearName:            gear.Name,
+					Remaining:           reminder.DueIn(bike.Distance),
+				})
+			}
+		}
+		stats = append(stats, bike)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Distance > stats[j].Distance
+	})
+	return stats, nil
+}
+
+// refreshBikes stores bikes of the athlete with their distance from Strava
+func refreshBikes(athleteID int, accessToken string) error {
+	bikes, err := getAthleteBikes(accessToken)
+	if err != nil {
+		return err
+	}
+	return SetBikes(athleteID, bikes)
+}
+
+// getMaintenanceDue returns maintenance reminders of the bike. Bikes of the
+// athlete are refreshed from Strava, so the distance of the bike is current
+func getMaintenanceDue(athleteID int, accessToken string, gearID string) ([]MaintenanceDue, error) {
+	if gearID == "" {
+		return nil, nil
+	}
+	reminders, err := GetMaintenanceReminders(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, nil
+	}
+	err = refreshBikes(athleteID, accessToken)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := getBikeStats(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	for _, bike := range stats {
+		if bike.ID == gearID {
+			return bike.Reminders, nil
+		}
+	}
+	return nil, nil
+}
+
+// updateMaintenanceReminders adds, marks as serviced or deletes maintenance
+// reminder of the athlete based on the submitted account form
+// Notes:
+//   - bikes are refreshed from Strava before the distance of the service is
+//     recorded, because reminders are checked against the Strava distance
+func updateMaintenanceReminders(athleteID int, r *http.Request) error {
+	reminders, err := GetMaintenanceReminders(athleteID)
+	if err != nil {
+		return err
+	}
+	if r.FormValue("action") != "deleteMaintenance" {
+		accessToken, err := RefreshAccessToken(athleteID)
+		if err != nil {
+			return err
+		}
+		err = refreshBikes(athleteID, accessToken)
+		if err != nil {
+			return err
+		}
+	}
+	stats, err := getBikeStats(athleteID)
+	if err != nil {
+		return err
+	}
+	gearDistance := func(gearID string) float64 {
+		for _, bike := range stats {
+			if bike.ID == gearID {
+				return bike.Distance
+			}
+		}
+		return 0
+	}
+
+	switch r.FormValue("action") {
+	case "addMaintenance":
+		interval, err := strconv.ParseFloat(r.FormValue("interval"), 64)
+		if err != nil {
+			return err
+		}
+		if interval <= 0 || r.FormValue("component") == "" || r.FormValue("gear") == "" {
+			return fmt.Errorf("bike, component and interval are required")
+		}
+		id, err := GenerateRandomID(6)
+		if err != nil {
+			return err
+		}
+		reminders = append(reminders, MaintenanceReminder{
+			ID:         id,
+			GearID:     r.FormValue("gear"),
+			Component:  r.FormValue("component"),
+			Interval:   interval * 1000,
+			ServicedAt: gearDistance(r.FormValue("gear")),
+		})
+	case "serviceMaintenance":
+		for i := range reminders {
+			if reminders[i].ID == r.FormValue("id") {
+				reminders[i].ServicedAt = gearDistance(reminders[i].GearID)
+			}
+		}
+	case "deleteMaintenance":
+		kept := []MaintenanceReminder{}
+		for _, reminder := range reminders {
+			if reminder.ID != r.FormValue("id") {
+				kept = append(kept, reminder)
+			}
+		}
+		reminders = kept
+	}
+	return SetMaintenanceReminders(athleteID, reminders)
+}
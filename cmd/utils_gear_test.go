@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_getBikeStats(t *testing.T) {
+	setupTestDB(t)
+	err := SetBikes(1, []Gear{
+		{ID: "b1", Name: "Gravel", Distance: 1500000},
+		{ID: "b2", Name: "Road", Distance: 0},
+		{ID: "b4", Name: "Commuter", Distance: 10000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveActivities(1, []Activity{
+		{ID: 10, GearID: "b1", Distance: 40000},
+		{ID: 11, GearID: "b2", Distance: 30000},
+		{ID: 12, GearID: "b3", Distance: 20000},
+		{ID: 13, Distance: 10000},
+		{ID: 14, GearID: "b4", Distance: 25000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetMaintenanceReminders(1, []MaintenanceReminder{
+		{ID: "r1", GearID: "b1", Component: "chain", Interval: 500000, ServicedAt: 1200000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := getBikeStats(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []struct {
+		id       string
+		name     string
+		distance float64
+		rides    int
+	}{
+		// Strava knows more rides of the bike than stored
+		{"b1", "Gravel", 1500000, 1},
+		// Strava didn't report the distance
+		{"b2", "Road", 30000, 1},
+		// The bike was removed from Strava
+		{"b3", "b3", 20000, 1},
+		// Strava distance is used even if the stored rides add up to more
+		{"b4", "Commuter", 10000, 1},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("expected %d bikes, got %d", len(expected), len(stats))
+	}
+	for i, bike := range expected {
+		if stats[i].ID != bike.id || stats[i].Name != bike.name || stats[i].Distance != bike.distance || stats[i].Rides != bike.rides {
+			t.Errorf("expected %+v, got %+v", bike, *stats[i])
+		}
+	}
+	if len(stats[0].Reminders) != 1 || stats[0].Reminders[0].Remaining != 200000 || stats[0].Reminders[0].GearName != "Gravel" {
+		t.Errorf("expected the chain to be due in 200000 m, got %+v", stats[0].Reminders)
+	}
+}
+
+func Test_MaintenanceReminder_DueIn(t *testing.T) {
+	reminder := MaintenanceReminder{Interval: 500000, ServicedAt: 1000000}
+	for _, tc := range []struct {
+		gearDistance float64
+		expected     float64
+	}{
+		{1000000, 500000},
+		{1400000, 100000},
+		{1600000, -100000},
+	} {
+		actual := reminder.DueIn(tc.gearDistance)
+		if actual != tc.expected {
+			t.Errorf("%f: expected %f, got %f", tc.gearDistance, tc.expected, actual)
+		}
+	}
+}
+
+func Test_matchesGear(t *testing.T) {
+	for _, tc := range []struct {
+		gearID   string
+		gearIDs  []string
+		expected bool
+	}{
+		{"b1", nil, true},
+		{"", nil, true},
+		{"b1", []string{"b1", "b2"}, true},
+		{"b3", []string{"b1", "b2"}, false},
+		{"", []string{"b1"}, false},
+	} {
+		actual := matchesGear(Activity{GearID: tc.gearID}, tc.gearIDs)
+		if actual != tc.expected {
+			t.Errorf("%q in %v: expected %t, got %t", tc.gearID, tc.gearIDs, tc.expected, actual)
+		}
+	}
+}
+
+func Test_GoalGear(t *testing.T) {
+	setupTestDB(t)
+	gearIDs, err := GetGoalGear(1, 2023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gearIDs) != 0 {
+		t.Errorf("expected the goal to count all bikes by default, got %v", gearIDs)
+	}
+
+	err = SetGoalGear(1, 2023, []string{"b1", "b2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gearIDs, _ = GetGoalGear(1, 2023)
+	otherYear, _ := GetGoalGear(1, 2022)
+	if len(gearIDs) != 2 || gearIDs[0] != "b1" || gearIDs[1] != "b2" || len(otherYear) != 0 {
+		t.Errorf("expected bikes to be saved for 2023 only, got %v and %v", gearIDs, otherYear)
+	}
+
+	err = SetGoalGear(1, 2023, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gearIDs, _ = GetGoalGear(1, 2023)
+	if len(gearIDs) != 0 {
+		t.Errorf("expected the restriction to be removed, got %v", gearIDs)
+	}
+}
+
+func Test_distanceUntil_gear(t *testing.T) {
+	until := time.Date(2022, time.June, 1, 0, 0, 0, 0, time.UTC)
+	activities := []Activity{
+		{GearID: "b1", Distance: 10000, StartDate: time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{GearID: "b2", Distance: 20000, StartDate: time.Date(2022, time.May, 2, 0, 0, 0, 0, time.UTC)},
+		{GearID: "b1", Distance: 40000, StartDate: time.Date(2022, time.July, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if distance := distanceUntil(activities, until, nil); distance != 30000 {
+		t.Errorf("expected 30000 m on all bikes, got %f", distance)
+	}
+	if distance := distanceUntil(activities, until, []string{"b1"}); distance != 10000 {
+		t.Errorf("expected 10000 m on the goal bike, got %f", distance)
+	}
+}
@@ -10,8 +10,10 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jellydator/ttlcache/v3"
+	"golang.org/x/exp/slices"
 )
 
 // Authenticates user with Strava and save tokens in database
@@ -95,6 +97,12 @@ func register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name := strings.TrimSpace(stravaData.Athlete.Firstname + " " + stravaData.Athlete.Lastname)
+	err = SetAthleteName(stravaData.Athlete.ID, name)
+	if err != nil {
+		logger.Println(err)
+	}
+
 	accountID, err := GenerateRandomID(30)
 	logger.Printf("generating account id for athlete %d: %s", stravaData.Athlete.ID, accountID)
 	if err != nil {
@@ -106,9 +114,35 @@ func register(w http.ResponseWriter, r *http.Request) {
 
 	AccountCache.Set(accountID, stravaData.Athlete.ID, ttlcache.DefaultTTL)
 
+	// State contains the page which sent the athlete to Strava, e.g. an
+	// invite link
+	state := r.URL.Query().Get("state")
+	if strings.HasPrefix(state, "/") && !strings.HasPrefix(state, "//") {
+		returnURL, err := url.Parse(state)
+		if err == nil {
+			query := returnURL.Query()
+			query.Set("accountId", accountID)
+			returnURL.RawQuery = query.Encode()
+			http.Redirect(w, r, "https://"+rootDomain+returnURL.String(), http.StatusFound)
+			return
+		}
+	}
+
 	http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
 }
 
+// loggedInAthlete returns ID of the athlete identified by `accountId` query
+// parameter. Athletes who are not logged in are sent to Strava and brought
+// back to the same page afterwards
+func loggedInAthlete(w http.ResponseWriter, r *http.Request) (int, bool) {
+	item := AccountCache.Get(r.URL.Query().Get("accountId"))
+	if item == nil || item.Value() == 0 {
+		http.Redirect(w, r, stravaAuthorizeURL(r.URL.RequestURI()), http.StatusFound)
+		return 0, false
+	}
+	return item.Value(), true
+}
+
 func accountHandler(w http.ResponseWriter, r *http.Request) {
 	accountID := r.URL.Query().Get("accountId")
 	item := AccountCache.Get(accountID)
@@ -120,47 +154,324 @@ func accountHandler(w http.ResponseWriter, r *http.Request) {
 
 	switch r.Method {
 	case http.MethodGet:
-		tmplContent, err := TemplatesStorage.ReadFile("templates/account.html")
+		profile, err := GetProfileSettings(athleteID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// Parse the template content
-		tmpl, err := template.New("template").Parse(string(tmplContent))
+		description, err := GetDescriptionSettings(athleteID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// Render the template with the provided data
-		err = tmpl.Execute(w, map[string]interface{}{
-			"AthleteID": athleteID,
-		})
+		rollover, err := GetRolloverSettings(athleteID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-	case http.MethodPost:
-		err := r.ParseForm()
+		history, err := getYearSummaries(athleteID)
 		if err != nil {
-			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-
-		// Extract the form values
-		goalStr := r.FormValue("goal")
-		goalNumber, err := strconv.Atoi(goalStr)
+		streaks, err := getStreaks(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		eddington, err := getEddington(athleteID, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		bikes, err := getBikeStats(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		goalGear, err := GetGoalGear(athleteID, time.Now().Year())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type goalBike struct {
+			Gear
+			Selected bool
+		}
+		goalBikes := []goalBike{}
+		for _, bike := range bikes {
+			goalBikes = append(goalBikes, goalBike{Gear: bike.Gear, Selected: slices.Contains(goalGear, bike.ID)})
+		}
+		teams, err := getAthleteTeamsProgress(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		challenges, err := getAthleteChallenges(athleteID, false)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		tokens, err := GetAthleteAPITokens(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		webhooks, err := GetWebhooks(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		deliveries, err := GetWebhookDeliveries(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		email, err := GetEmailSettings(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		push, err := GetPushSettings(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		nudge, err := GetNudgeSettings(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		outbox, err := GetOutbox(athleteID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		audit, err := GetAuditLog(athleteID, AuditLogSize)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recapText := ""
+		recap, err := GetYearRecap(athleteID, time.Now().Year()-1)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		err = SetGoal(athleteID, float64(goalNumber))
+		if recap != nil {
+			recapText, err = renderYearRecap(recap)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		renderHTML(w, "templates/account.html", map[string]interface{}{
+			"AthleteID":    athleteID,
+			"AccountID":    accountID,
+			"Year":         time.Now().Year(),
+			"Profile":      profile,
+			"Description":  description,
+			"Rollover":     rollover,
+			"History":      history,
+			"Streaks":      streaks,
+			"Eddington":    eddington,
+			"Bikes":        bikes,
+			"GoalBikes":    goalBikes,
+			"Teams":        teams,
+			"Challenges":   challenges,
+			"SportTypes":   CyclingActivities,
+			"Metrics":      ChallengeMetrics,
+			"Tokens":       tokens,
+			"Webhooks":     webhooks,
+			"Deliveries":   deliveries,
+			"Events":       WebhookEvents,
+			"Email":        email,
+			"EmailEnabled": rootSMTPHost != "",
+			"Push":         push,
+			"PushServices": PushServices,
+			"Nudge":        nudge,
+			"Outbox":       outbox,
+			"Audit":        audit,
+			"Recap":        recap,
+			"RecapText":    recapText,
+			"Domain":       rootDomain,
+		})
+	case http.MethodPost:
+		err := r.ParseForm()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			http.Error(w, "Failed to parse form data", http.StatusBadRequest)
 			return
 		}
-		http.Redirect(w, r, "https://"+rootDomain+"/success", http.StatusFound)
+
+		switch r.FormValue("action") {
+		case "profile":
+			err = SetProfileSettings(athleteID, &ProfileSettings{
+				Public:          r.FormValue("public") != "",
+				ShowGoal:        r.FormValue("showGoal") != "",
+				ShowMonthly:     r.FormValue("showMonthly") != "",
+				ShowLongestRide: r.FormValue("showLongestRide") != "",
+				Leaderboard:     r.FormValue("leaderboard") != "",
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "description":
+			err = SetDescriptionSettings(athleteID, &DescriptionSettings{
+				YearOverYear: r.FormValue("yearOverYear") != "",
+				Streaks:      r.FormValue("streaks") != "",
+				Eddington:    r.FormValue("eddington") != "",
+				Maintenance:  r.FormValue("maintenance") != "",
+				Teams:        r.FormValue("teams") != "",
+				Challenges:   r.FormValue("challenges") != "",
+				Preview:      r.FormValue("preview") != "",
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "refreshBikes":
+			accessToken, err := RefreshAccessToken(athleteID)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			err = refreshBikes(athleteID, accessToken)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "addMaintenance", "serviceMaintenance", "deleteMaintenance":
+			err = updateMaintenanceReminders(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "createTeam", "leaveTeam":
+			err = updateTeams(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "createChallenge", "leaveChallenge":
+			err = updateChallenges(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "createToken":
+			token, err := createAPIToken(athleteID, strings.TrimSpace(r.FormValue("label")))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			// The token is shown only once, so it is not a redirect
+			renderHTML(w, "templates/token.html", map[string]interface{}{
+				"Token":     token,
+				"AccountID": accountID,
+				"Domain":    rootDomain,
+			})
+		case "email":
+			err = updateEmailSettings(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "push":
+			err = updatePushSettings(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "nudge":
+			err = updateNudgeSettings(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "applyOutbox", "discardOutbox":
+			err = updateOutbox(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "undoActivity", "undoYear", "undoAll":
+			err = updateUndo(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "addWebhook", "deleteWebhook", "testWebhook":
+			err = updateWebhooks(athleteID, r)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "deleteToken":
+			err = DeleteAPIToken(athleteID, r.FormValue("hash"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		case "rollover":
+			mode := r.FormValue("mode")
+			if mode != RolloverOff && mode != RolloverCopy && mode != RolloverIncrease {
+				http.Error(w, "Unknown rollover mode", http.StatusBadRequest)
+				return
+			}
+			percent, err := strconv.ParseFloat(r.FormValue("percent"), 64)
+			if err != nil && mode == RolloverIncrease {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = SetRolloverSettings(athleteID, &RolloverSettings{Mode: mode, Percent: percent})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/account?accountId="+accountID, http.StatusFound)
+		default:
+			// Extract the form values
+			goalStr := r.FormValue("goal")
+			goalNumber, err := strconv.Atoi(goalStr)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			year := time.Now().Year()
+			if r.FormValue("year") != "" {
+				year, err = strconv.Atoi(r.FormValue("year"))
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			err = SetGoal(athleteID, year, float64(goalNumber))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			err = SetGoalGear(athleteID, year, r.Form["gear"])
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			http.Redirect(w, r, "https://"+rootDomain+"/success", http.StatusFound)
+		}
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
@@ -188,27 +499,6 @@ func successHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// Subscribes app to Strava webhooks. Done only once
-func subscribeToWebhook(w http.ResponseWriter, r *http.Request) {
-	logger, ok := r.Context().Value(HL).(*log.Logger)
-	if !ok {
-		logger = Logger
-	}
-	data := url.Values{}
-	data.Add("client_id", rootAppID)
-	data.Add("client_secret", rootAppSecret)
-	data.Add("callback_url", "https://"+rootDomain+"/webhook")
-	data.Add("verify_token", rootAppVerifyToken)
-
-	resp, err := http.PostForm(StravaWebhookSubscribeURL, data)
-	if err != nil {
-		logger.Println(err)
-		http.Error(w, err.Error(), http.StatusBadGateway)
-		return
-	}
-	defer resp.Body.Close()
-}
-
 // rootHandler is the entry point for a new user to register in the app
 func rootHandler(w http.ResponseWriter, r *http.Request) {
 	if r.URL.Path != "/" {
@@ -268,9 +558,14 @@ func webhook(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		if !checkSubscription(data.SubscriptionID, time.Now()) {
+			logger.Printf("event from unknown subscription %d\n", data.SubscriptionID)
+			http.Error(w, "Unknown subscription", http.StatusForbidden)
+			return
+		}
 		if data.AspectType != "delete" && data.ObjectType == "activity" {
 			logger.Printf("new activity %d for user %d\n", data.ObjectID, data.OwnerID)
-			go addCommentToActivity(data.ObjectID, data.OwnerID)
+			handleActivityEvent(data.ObjectID, data.OwnerID)
 		}
 	case "GET":
 		// Callback validation
@@ -302,6 +597,7 @@ func webhook(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
 		w.Write(jsonPayload)
 		logger.Println("responded to webhook validation request")
+		time.AfterFunc(SubscriptionValidationDelay, refreshSubscription)
 		return
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -0,0 +1,294 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// SchedulerTick is how often the scheduler checks for due jobs
+var SchedulerTick = time.Minute
+
+// CronSchedule is a parsed cron expression with 5 fields: minute, hour, day
+// of month, month and day of week. All times are in UTC
+// Notes:
+//   - fields support `*`, lists `1,2`, ranges `1-5` and steps `*/15`
+//   - like in cron, when both day of month and day of week are restricted,
+//     the job runs when either matches
+type CronSchedule struct {
+	minutes  map[int]bool
+	hours    map[int]bool
+	days     map[int]bool
+	months   map[int]bool
+	weekdays map[int]bool
+	anyDay   bool
+	anyWeek  bool
+}
+
+// parseCronField parses one field of the cron expression
+func parseCronField(field string, min int, max int) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		start, end := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			start, err = strconv.Atoi(from)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value in %q", part)
+			}
+			end = start
+			if isRange {
+				end, err = strconv.Atoi(to)
+				if err != nil {
+					return nil, fmt.Errorf("invalid range in %q", part)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for i := start; i <= end; i += step {
+			values[i] = true
+		}
+	}
+	return values, nil
+}
+
+// parseCron parses the cron expression, e.g. `0 7 * * 1` for every Monday at
+// 07:00 UTC
+func parseCron(expr string) (*CronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q must have 5 fields", expr)
+	}
+	schedule := &CronSchedule{
+		anyDay:  fields[2] == "*",
+		anyWeek: fields[4] == "*",
+	}
+	var err error
+	for i, target := range []*map[int]bool{&schedule.minutes, &schedule.hours, &schedule.days, &schedule.months, &schedule.weekdays} {
+		limits := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}[i]
+		*target, err = parseCronField(fields[i], limits[0], limits[1])
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %s", expr, err)
+		}
+	}
+	// Both 0 and 7 mean Sunday
+	if schedule.weekdays[7] {
+		schedule.weekdays[0] = true
+	}
+	return schedule, nil
+}
+
+// matchesDay reports whether the job runs on the day of `t`
+func (s *CronSchedule) matchesDay(t time.Time) bool {
+	day := s.days[t.Day()]
+	weekday := s.weekdays[int(t.Weekday())]
+	switch {
+	case s.anyDay && s.anyWeek:
+		return true
+	case s.anyDay:
+		return weekday
+	case s.anyWeek:
+		return day
+	default:
+		return day || weekday
+	}
+}
+
+// Next returns the first time after `t` when the job runs
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Every schedule repeats within 5 years, e.g. February 29
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// JobState is the persisted state of the scheduled job
+type JobState struct {
+	LastRun time.Time `json:"last_run"`
+}
+
+// Job is a periodic job registered with the scheduler
+type Job struct {
+	Name     string
+	Schedule string
+	cron     *CronSchedule
+	run      func()
+	running  bool
+}
+
+// Scheduler runs registered jobs according to their cron schedules
+// Notes:
+//   - the last run of every job is persisted in the JobBucket, so a job runs
+//     once per scheduled time even if the application restarts
+//   - runs missed while the application was down are caught up once on start
+//   - a job is never started again while its previous run is in progress
+type Scheduler struct {
+	mu   sync.Mutex
+	jobs []*Job
+}
+
+// NewScheduler creates an empty scheduler
+func NewScheduler() *Scheduler {
+	return &Scheduler{}
+}
+
+// Register adds the job to the scheduler
+func (s *Scheduler) Register(name string, expr string, run func()) error {
+	cron, err := parseCron(expr)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Name == name {
+			return fmt.Errorf("job %s is already registered", name)
+		}
+	}
+	s.jobs = append(s.jobs, &Job{Name: name, Schedule: expr, cron: cron, run: run})
+	return nil
+}
+
+// claimJob atomically checks whether the job is due at `now` and records the
+// run. Jobs without a state are scheduled from `now` and are not due
+func claimJob(job *Job, now time.Time) (bool, error) {
+	due := false
+	err := DB.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(JobBucket)
+		state := &JobState{}
+		data := bucket.Get([]byte(job.Name))
+		if data != nil {
+			err := json.Unmarshal(data, state)
+			if err != nil {
+				return err
+			}
+			next := job.cron.Next(state.LastRun)
+			if next.IsZero() || next.After(now) {
+				return nil
+			}
+			due = true
+		}
+		state.LastRun = now.UTC()
+		data, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(job.Name), data)
+	})
+	return due, err
+}
+
+// GetJobState returns the persisted state of the job. Returns nil if the job
+// never ran
+func GetJobState(name string) (*JobState, error) {
+	var state *JobState
+	err := DB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(JobBucket).Get([]byte(name))
+		if data == nil {
+			return nil
+		}
+		state = &JobState{}
+		return json.Unmarshal(data, state)
+	})
+	return state, err
+}
+
+// runDue starts all jobs which are due at `now` in the background
+func (s *Scheduler) runDue(now time.Time) *sync.WaitGroup {
+	var wg sync.WaitGroup
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.running {
+			continue
+		}
+		due, err := claimJob(job, now)
+		if err != nil {
+			Logger.Printf("failed to schedule job %s: %s\n", job.Name, err)
+			continue
+		}
+		if !due {
+			continue
+		}
+
+		job.running = true
+		wg.Add(1)
+		go func(job *Job) {
+			defer wg.Done()
+			Logger.Printf("running job %s\n", job.Name)
+			start := time.Now()
+			job.run()
+			Logger.Printf("job %s took %s\n", job.Name, time.Since(start))
+			s.mu.Lock()
+			job.running = false
+			s.mu.Unlock()
+		}(job)
+	}
+	return &wg
+}
+
+// Start checks for due jobs every `SchedulerTick`. It never returns, so it is
+// supposed to be started in a goroutine
+func (s *Scheduler) Start() {
+	for {
+		s.runDue(time.Now())
+		time.Sleep(SchedulerTick)
+	}
+}
+
+// goalRolloverJob rolls goals over to the new year for all athletes, so they
+// are visible before the first ride of the year
+func goalRolloverJob() {
+	year := time.Now().Year()
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for _, athleteID := range athleteIDs {
+		err = RolloverGoal(athleteID, year)
+		if err != nil && !errors.Is(err, ErrGoalNotFound) {
+			Logger.Printf("failed to roll over goal of athlete %d: %s\n", athleteID, err)
+		}
+	}
+}
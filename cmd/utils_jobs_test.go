@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func Test_parseCron_invalid(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		_, err := parseCron(expr)
+		if err == nil {
+			t.Errorf("expected error for %q", expr)
+		}
+	}
+}
+
+func Test_CronSchedule_Next(t *testing.T) {
+	// Wednesday
+	now := time.Date(2023, time.October, 18, 10, 30, 0, 0, time.UTC)
+	tests := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"* * * * *", time.Date(2023, time.October, 18, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2023, time.October, 18, 10, 45, 0, 0, time.UTC)},
+		{"0 1 * * *", time.Date(2023, time.October, 19, 1, 0, 0, 0, time.UTC)},
+		{"0 7 * * 1", time.Date(2023, time.October, 23, 7, 0, 0, 0, time.UTC)},
+		{"0 7 * * 7", time.Date(2023, time.October, 22, 7, 0, 0, 0, time.UTC)},
+		{"30 10 18 10 *", time.Date(2024, time.October, 18, 10, 30, 0, 0, time.UTC)},
+		{"5 0 1 1 *", time.Date(2024, time.January, 1, 0, 5, 0, 0, time.UTC)},
+		{"0 12 29 2 *", time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)},
+		// Either day of month or day of week
+		{"0 0 1 * 5", time.Date(2023, time.October, 20, 0, 0, 0, 0, time.UTC)},
+		{"0 9-17/4 * * 1-5", time.Date(2023, time.October, 18, 13, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := parseCron(tt.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next := schedule.Next(now)
+		if !next.Equal(tt.expected) {
+			t.Errorf("%q: expected %s, got %s", tt.expr, tt.expected, next)
+		}
+	}
+}
+
+func Test_Scheduler(t *testing.T) {
+	setupTestDB(t)
+	runs := 0
+	scheduler := NewScheduler()
+	err := scheduler.Register("test", "0 7 * * *", func() { runs++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scheduler.Register("test", "* * * * *", func() {}) == nil {
+		t.Errorf("expected error for duplicate job")
+	}
+
+	// The first tick only records the state
+	now := time.Date(2023, time.October, 18, 6, 0, 0, 0, time.UTC)
+	scheduler.runDue(now).Wait()
+	if runs != 0 {
+		t.Fatalf("expected no runs before the scheduled time, got %d", runs)
+	}
+	scheduler.runDue(now.Add(30 * time.Minute)).Wait()
+	scheduler.runDue(now.Add(time.Hour)).Wait()
+	scheduler.runDue(now.Add(time.Hour + time.Minute)).Wait()
+	if runs != 1 {
+		t.Fatalf("expected one run at 07:00, got %d", runs)
+	}
+
+	// Restarted application doesn't run the job again, but catches up
+	// missed runs once
+	restarted := NewScheduler()
+	err = restarted.Register("test", "0 7 * * *", func() { runs++ })
+	if err != nil {
+		t.Fatal(err)
+	}
+	restarted.runDue(now.Add(2 * time.Hour)).Wait()
+	if runs != 1 {
+		t.Fatalf("expected no runs after restart, got %d", runs)
+	}
+	restarted.runDue(now.AddDate(0, 0, 3)).Wait()
+	restarted.runDue(now.AddDate(0, 0, 3).Add(time.Minute)).Wait()
+	if runs != 2 {
+		t.Fatalf("expected one catch-up run, got %d", runs)
+	}
+
+	state, err := GetJobState("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !state.LastRun.Equal(now.AddDate(0, 0, 3)) {
+		t.Errorf("expected last run to be persisted, got %s", state.LastRun)
+	}
+}
+
+func Test_RolloverGoal(t *testing.T) {
+	setupTestDB(t)
+	err := SetGoal(1, 2022, 3000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetGoalGear(1, 2022, []string{"b1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetRolloverSettings(1, &RolloverSettings{Mode: RolloverIncrease, Percent: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	goal, err := GetGoal(1, 2023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Round(goal) != 3300000 {
+		t.Errorf("expected the rolled over goal 3300000, got %f", goal)
+	}
+	goals, _ := GetGoals(1)
+	if _, ok := goals[2023]; ok {
+		t.Errorf("expected GetGoal not to save the rolled over goal")
+	}
+
+	err = RolloverGoal(1, 2023)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goals, _ = GetGoals(1)
+	gearIDs, _ := GetGoalGear(1, 2023)
+	if math.Round(goals[2023]) != 3300000 || len(gearIDs) != 1 || gearIDs[0] != "b1" {
+		t.Errorf("expected the goal and bikes to be rolled over, got %v %v", goals, gearIDs)
+	}
+
+	err = SetRolloverSettings(1, &RolloverSettings{Mode: RolloverOff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = GetGoal(1, 2024)
+	if !errors.Is(err, ErrGoalNotFound) {
+		t.Errorf("expected ErrGoalNotFound without rollover, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Supported leaderboard periods
+var LeaderboardPeriods = []string{"week", "month", "year"}
+
+// Supported leaderboard metrics
+var LeaderboardMetrics = []string{"distance", "elevation", "rides", "goal"}
+
+// LeaderboardEntry is the result of the athlete in the leaderboard
+// Notes:
+//   - all distance is in meters
+//   - `GoalProgress` is the distance of the period in percents of the yearly goal
+type LeaderboardEntry struct {
+	Rank         int     `json:"rank"`
+	AthleteID    int     `json:"athlete_id"`
+	Name         string  `json:"name"`
+	Distance     float64 `json:"distance"`
+	Elevation    float64 `json:"elevation"`
+	Rides        int     `json:"rides"`
+	GoalProgress float64 `json:"goal_progress"`
+}
+
+// LeaderboardAthlete contains data of the athlete needed to build a leaderboard
+type LeaderboardAthlete struct {
+	ID         int
+	Name       string
+	Goal       float64
+	Activities []Activity
+}
+
+// periodStart returns the beginning of the period which contains `now`
+func periodStart(period string, now time.Time) time.Time {
+	today := localDay(now)
+	switch period {
+	case "week":
+		return startOfWeek(today)
+	case "month":
+		return time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// computeLeaderboard ranks athletes by the metric for the period which
+// contains `now`
+func computeLeaderboard(athletes []LeaderboardAthlete, period string, metric string, now time.Time) []LeaderboardEntry {
+	start := periodStart(period, now)
+	entries := []LeaderboardEntry{}
+	for _, athlete := range athletes {
+		entry := LeaderboardEntry{AthleteID: athlete.ID, Name: athlete.Name}
+		for _, activity := range athlete.Activities {
+			day := localDay(activity.StartDateLocal)
+			if day.Before(start) || day.After(localDay(now)) {
+				continue
+			}
+			entry.Distance += activity.Distance
+			entry.Elevation += activity.TotalElevationGain
+			entry.Rides++
+		}
+		if athlete.Goal > 0 {
+			entry.GoalProgress = entry.Distance / athlete.Goal * 100
+		}
+		entries = append(entries, entry)
+	}
+
+	value := func(entry LeaderboardEntry) float64 {
+		switch metric {
+		case "elevation":
+			return entry.Elevation
+		case "rides":
+			return float64(entry.Rides)
+		case "goal":
+			return entry.GoalProgress
+		default:
+			return entry.Distance
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return value(entries[i]) > value(entries[j])
+	})
+	for i := range entries {
+		entries[i].Rank = i + 1
+		if i > 0 && value(entries[i]) == value(entries[i-1]) {
+			entries[i].Rank = entries[i-1].Rank
+		}
+	}
+	return entries
+}
+
+// parseLeaderboardGroups parses groups configured by the operator in the
+// `name=1,2,3` format
+func parseLeaderboardGroups(groups []string) (map[string][]int, error) {
+	result := map[string][]int{}
+	for _, group := range groups {
+		name, ids, found := strings.Cut(group, "=")
+		if !found || name == "" {
+			return nil, fmt.Errorf("invalid group %q, expected name=1,2,3", group)
+		}
+		for _, id := range strings.Split(ids, ",") {
+			athleteID, err := strconv.Atoi(strings.TrimSpace(id))
+			if err != nil {
+				return nil, fmt.Errorf("invalid athlete ID in group %q: %s", name, err)
+			}
+			result[name] = append(result[name], athleteID)
+		}
+	}
+	return result, nil
+}
+
+// getLeaderboardAthletes returns athletes of the group or all athletes who
+// opted in if the group is empty
+func getLeaderboardAthletes(group string) ([]LeaderboardAthlete, error) {
+	var athleteIDs []int
+	if group != "" {
+		groups, err := parseLeaderboardGroups(rootLeaderboardGroups)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		athleteIDs, ok = groups[group]
+		if !ok {
+			return nil, fmt.Errorf("group %s doesn't exist", group)
+		}
+	} else {
+		allIDs, err := GetAthleteIDs()
+		if err != nil {
+			return nil, err
+		}
+		for _, athleteID := range allIDs {
+			settings, err := GetProfileSettings(athleteID)
+			if err != nil {
+				return nil, err
+			}
+			if settings.Leaderboard {
+				athleteIDs = append(athleteIDs, athleteID)
+			}
+		}
+	}
+
+	athletes := []LeaderboardAthlete{}
+	for _, athleteID := range athleteIDs {
+		activities, err := GetActivities(athleteID)
+		if err != nil {
+			return nil, err
+		}
+		goal, _ := GetGoal(athleteID, time.Now().Year())
+		athletes = append(athletes, LeaderboardAthlete{
+			ID:         athleteID,
+			Name:       GetAthleteName(athleteID),
+			Goal:       goal,
+			Activities: activities,
+		})
+	}
+	return athletes, nil
+}
+
+// leaderboardHandler renders the leaderboard as HTML page or as JSON when
+// requested via /leaderboard.json
+func leaderboardHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "week"
+	}
+	metric := r.URL.Query().Get("by")
+	if metric == "" {
+		metric = "distance"
+	}
+	if !slices.Contains(LeaderboardPeriods, period) || !slices.Contains(LeaderboardMetrics, metric) {
+		http.Error(w, "Unknown period or metric", http.StatusBadRequest)
+		return
+	}
+	group := r.URL.Query().Get("group")
+
+	athletes, err := getLeaderboardAthletes(group)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	entries := computeLeaderboard(athletes, period, metric, time.Now())
+
+	if strings.HasSuffix(r.URL.Path, ".json") {
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(map[string]interface{}{
+			"period":  period,
+			"metric":  metric,
+			"group":   group,
+			"entries": entries,
+		})
+		if err != nil {
+			logger.Println(err)
+		}
+		return
+	}
+
+	renderHTML(w, "templates/leaderboard.html", map[string]interface{}{
+		"Period":  period,
+		"Metric":  metric,
+		"Group":   group,
+		"Periods": LeaderboardPeriods,
+		"Metrics": LeaderboardMetrics,
+		"Entries": entries,
+	})
+}
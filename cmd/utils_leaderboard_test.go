@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_computeLeaderboard(t *testing.T) {
+	// Wednesday
+	now := time.Date(2023, time.March, 15, 8, 0, 0, 0, time.UTC)
+	athletes := []LeaderboardAthlete{
+		{ID: 1, Goal: 1000000, Activities: []Activity{
+			{Distance: 50000, TotalElevationGain: 100, StartDateLocal: time.Date(2023, time.March, 13, 8, 0, 0, 0, time.UTC)},
+			{Distance: 90000, TotalElevationGain: 900, StartDateLocal: time.Date(2023, time.March, 1, 8, 0, 0, 0, time.UTC)},
+		}},
+		{ID: 2, Goal: 100000, Activities: []Activity{
+			{Distance: 20000, TotalElevationGain: 500, StartDateLocal: time.Date(2023, time.March, 14, 8, 0, 0, 0, time.UTC)},
+			{Distance: 20000, TotalElevationGain: 500, StartDateLocal: time.Date(2023, time.March, 15, 7, 0, 0, 0, time.UTC)},
+		}},
+		{ID: 3},
+	}
+
+	cases := []struct {
+		period string
+		metric string
+		order  []int
+	}{
+		{"week", "distance", []int{1, 2, 3}},
+		{"week", "elevation", []int{2, 1, 3}},
+		{"week", "rides", []int{2, 1, 3}},
+		{"week", "goal", []int{2, 1, 3}},
+		{"month", "distance", []int{1, 2, 3}},
+	}
+	for _, c := range cases {
+		entries := computeLeaderboard(athletes, c.period, c.metric, now)
+		for i, athleteID := range c.order {
+			if entries[i].AthleteID != athleteID || entries[i].Rank != i+1 {
+				t.Errorf("%s by %s: expected athlete %d at %d, got %+v", c.period, c.metric, athleteID, i+1, entries)
+				break
+			}
+		}
+	}
+}
+
+func Test_parseLeaderboardGroups(t *testing.T) {
+	groups, err := parseLeaderboardGroups([]string{"club=1, 2,3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups["club"]) != 3 || groups["club"][1] != 2 {
+		t.Errorf("unexpected groups %v", groups)
+	}
+
+	_, err = parseLeaderboardGroups([]string{"club"})
+	if err == nil {
+		t.Error("expected error for group without athletes")
+	}
+}
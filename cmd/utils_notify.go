@@ -0,0 +1,214 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Kinds of notifications
+const (
+	NotifyMilestone = "milestone"
+	NotifyBehind    = "behind"
+)
+
+// Supported push services
+var PushServices = []string{"ntfy", "gotify"}
+
+// NotifyClient is the HTTP client used to send push notifications. It
+// connects only to public addresses
+var NotifyClient = newPublicClient(10 * time.Second)
+
+// Notification is a message to the athlete
+type Notification struct {
+	Kind    string
+	Title   string
+	Message string
+	// Link is opened when the athlete clicks on the notification
+	Link string
+}
+
+// Notifier delivers notifications to the athlete
+type Notifier interface {
+	Notify(notification *Notification) error
+}
+
+// EmailNotifier sends notifications by email
+type EmailNotifier struct {
+	Address     string
+	Unsubscribe string
+}
+
+// Notify sends the notification as a plain text email with the unsubscribe
+// link
+func (n *EmailNotifier) Notify(notification *Notification) error {
+	body := notification.Message
+	if notification.Link != "" {
+		body += "\n" + notification.Link
+	}
+	body += "\n\n-- \nUnsubscribe: " + n.Unsubscribe + "\n"
+	return sendEmail(n.Address, notification.Title, body, n.Unsubscribe)
+}
+
+// PushNotifier sends notifications to ntfy topics or Gotify servers
+type PushNotifier struct {
+	Service string
+	URL     string
+	Token   string
+}
+
+// Notify publishes the notification using the API of the push service
+func (n *PushNotifier) Notify(notification *Notification) error {
+	var req *http.Request
+	var err error
+	switch n.Service {
+	case "gotify":
+		data, err := json.Marshal(map[string]interface{}{
+			"title":    notification.Title,
+			"message":  notification.Message,
+			"priority": 5,
+			"extras": map[string]interface{}{
+				"client::notification": map[string]interface{}{
+					"click": map[string]string{"url": notification.Link},
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		req, err = http.NewRequest(http.MethodPost, strings.TrimSuffix(n.URL, "/")+"/message", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Gotify-Key", n.Token)
+	default:
+		req, err = http.NewRequest(http.MethodPost, n.URL, strings.NewReader(notification.Message))
+		if err != nil {
+			return err
+		}
+		// Non-ASCII headers have to be encoded
+		req.Header.Set("Title", mime.QEncoding.Encode("utf-8", notification.Title))
+		req.Header.Set("Tags", "bicyclist")
+		if notification.Link != "" {
+			req.Header.Set("Click", notification.Link)
+		}
+		if n.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+n.Token)
+		}
+	}
+
+	resp, err := NotifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %s", n.Service, resp.Status)
+	}
+	return nil
+}
+
+// athleteNotifiers returns notifiers which the athlete enabled for the kind
+// of notifications
+func athleteNotifiers(athleteID int, kind string) ([]Notifier, error) {
+	notifiers := []Notifier{}
+
+	email, err := GetEmailSettings(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	if email.Address != "" && rootSMTPHost != "" {
+		switch {
+		case kind == NotifyMilestone && email.Milestones:
+			notifiers = append(notifiers, &EmailNotifier{Address: email.Address, Unsubscribe: unsubscribeURL(email, "milestones")})
+		case kind == NotifyBehind && email.Behind:
+			notifiers = append(notifiers, &EmailNotifier{Address: email.Address, Unsubscribe: unsubscribeURL(email, "behind")})
+		}
+	}
+
+	push, err := GetPushSettings(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	if push.URL != "" && (kind == NotifyMilestone && push.Milestones || kind == NotifyBehind && push.Behind) {
+		notifiers = append(notifiers, &PushNotifier{Service: push.Service, URL: push.URL, Token: push.Token})
+	}
+	return notifiers, nil
+}
+
+// notifyAthlete sends the notification through all notifiers the athlete
+// enabled for it. Returns the last error, but tries every notifier
+func notifyAthlete(athleteID int, notification *Notification) error {
+	notifiers, err := athleteNotifiers(athleteID, notification.Kind)
+	if err != nil {
+		return err
+	}
+	var lastErr error
+	for _, notifier := range notifiers {
+		err = notifier.Notify(notification)
+		if err != nil {
+			Logger.Printf("failed to notify athlete %d: %s\n", athleteID, err)
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// sendMilestoneNotification notifies the athlete about the crossed milestone
+// or the achieved goal
+func sendMilestoneNotification(athleteID int, event string, data MilestoneEventData) error {
+	title := fmt.Sprintf("You've ridden %.0f km this year", data.Milestone/1000)
+	if event == EventGoalAchieved {
+		title = "You've achieved your goal 🏆"
+	}
+	message, err := renderTextTemplate("notification_milestone.txt", map[string]interface{}{
+		"Event": event,
+		"Data":  data,
+	})
+	if err != nil {
+		return err
+	}
+	return notifyAthlete(athleteID, &Notification{
+		Kind:    NotifyMilestone,
+		Title:   title,
+		Message: strings.TrimSpace(message),
+		Link:    fmt.Sprintf("https://www.strava.com/activities/%d", data.ActivityID),
+	})
+}
+
+// updatePushSettings saves the push endpoint from the submitted account form
+func updatePushSettings(athleteID int, r *http.Request) error {
+	service := r.FormValue("service")
+	if !slices.Contains(PushServices, service) {
+		return fmt.Errorf("unknown push service %s", service)
+	}
+	endpoint := strings.TrimSpace(r.FormValue("url"))
+	if endpoint != "" {
+		parsed, err := url.Parse(endpoint)
+		if err != nil {
+			return err
+		}
+		if parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("push URL must be an HTTPS URL")
+		}
+		err = checkPublicHost(parsed)
+		if err != nil {
+			return err
+		}
+	}
+	return SetPushSettings(athleteID, &PushSettings{
+		Service:    service,
+		URL:        endpoint,
+		Token:      strings.TrimSpace(r.FormValue("token")),
+		Milestones: r.FormValue("milestones") != "",
+		Behind:     r.FormValue("behind") != "",
+	})
+}
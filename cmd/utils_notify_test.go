@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// setNotifyClient replaces the client of push notifications for the test
+func setNotifyClient(t *testing.T, client *http.Client) {
+	previous := NotifyClient
+	NotifyClient = client
+	t.Cleanup(func() { NotifyClient = previous })
+}
+
+func Test_PushNotifier_ntfy(t *testing.T) {
+	var headers http.Header
+	var body string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		headers, body = r.Header, string(data)
+	}))
+	defer server.Close()
+	setNotifyClient(t, server.Client())
+
+	notifier := &PushNotifier{Service: "ntfy", URL: server.URL + "/cycling", Token: "tk_secret"}
+	err := notifier.Notify(&Notification{Title: "1000 km", Message: "Keep going", Link: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "Keep going" || headers.Get("Title") != "1000 km" || headers.Get("Click") != "https://example.com" {
+		t.Errorf("unexpected ntfy request %s %v", body, headers)
+	}
+	if headers.Get("Authorization") != "Bearer tk_secret" {
+		t.Errorf("expected access token, got %s", headers.Get("Authorization"))
+	}
+}
+
+func Test_PushNotifier_gotify(t *testing.T) {
+	var message map[string]interface{}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/message" || r.Header.Get("X-Gotify-Key") != "app-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&message)
+	}))
+	defer server.Close()
+	setNotifyClient(t, server.Client())
+
+	notifier := &PushNotifier{Service: "gotify", URL: server.URL + "/", Token: "app-token"}
+	err := notifier.Notify(&Notification{Title: "1000 km", Message: "Keep going"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message["title"] != "1000 km" || message["message"] != "Keep going" {
+		t.Errorf("unexpected gotify message %v", message)
+	}
+
+	notifier.Token = "wrong"
+	err = notifier.Notify(&Notification{Title: "1000 km"})
+	if err == nil {
+		t.Errorf("expected error for rejected notification")
+	}
+}
+
+func Test_sendMilestoneNotification_push(t *testing.T) {
+	setupTestDB(t)
+	var titles []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		title, _ := new(mime.WordDecoder).DecodeHeader(r.Header.Get("Title"))
+		titles = append(titles, title)
+	}))
+	defer server.Close()
+	setNotifyClient(t, server.Client())
+
+	err := SetPushSettings(1, &PushSettings{Service: "ntfy", URL: server.URL, Milestones: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendMilestoneNotification(1, EventMilestoneCrossed, MilestoneEventData{Milestone: 1000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 0 {
+		t.Fatalf("expected no notifications when milestones are disabled, got %v", titles)
+	}
+
+	err = SetPushSettings(1, &PushSettings{Service: "ntfy", URL: server.URL, Milestones: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sendMilestoneNotification(1, EventGoalAchieved, MilestoneEventData{Milestone: 5000000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(titles) != 1 || titles[0] != "You've achieved your goal 🏆" {
+		t.Errorf("expected goal notification, got %v", titles)
+	}
+}
+
+func Test_updatePushSettings_url(t *testing.T) {
+	setupTestDB(t)
+	for _, tc := range []struct {
+		url   string
+		valid bool
+	}{
+		{"", true},
+		{"https://ntfy.sh/cycling", true},
+		{"http://ntfy.sh/cycling", false},
+		{"https://localhost/cycling", false},
+		{"https://169.254.169.254/latest", false},
+		{"https://[fdaa::3]:8080/message", false},
+	} {
+		form := url.Values{"service": {"ntfy"}, "url": {tc.url}}
+		r := httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		err := updatePushSettings(1, r)
+		if (err == nil) != tc.valid {
+			t.Errorf("%q: expected valid %t, got %v", tc.url, tc.valid, err)
+		}
+	}
+}
+
+func Test_PushNotifier_private_address(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+
+	notifier := &PushNotifier{Service: "ntfy", URL: server.URL}
+	err := notifier.Notify(&Notification{Title: "1000 km"})
+	if !errors.Is(err, errPrivateAddress) || requests != 0 {
+		t.Errorf("expected the loopback address to be refused, got %v", err)
+	}
+}
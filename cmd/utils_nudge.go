@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NudgeInterval is the minimal time between two nudges of the athlete
+const NudgeInterval = 7 * 24 * time.Hour
+
+// Nudge is the reason to remind the athlete about the goal
+// Notes:
+//   - all distance is in meters
+//   - `Behind` is the distance behind the schedule, negative when ahead
+//   - `InactiveDays` is counted from the start of the year if the athlete has
+//     no rides, `NoRides` is set then
+type Nudge struct {
+	Behind          float64
+	InactiveDays    int
+	NoRides         bool
+	RequiredPerWeek float64
+}
+
+// computeNudge returns a nudge if the athlete is behind the schedule by more
+// than the threshold or hasn't ridden for too long. Returns nil otherwise.
+// `now` must be in athlete's time zone
+func computeNudge(activities []Activity, goal float64, goalGear []string, settings *NudgeSettings, now time.Time) *Nudge {
+	if goal == 0 {
+		return nil
+	}
+	today := localDay(now)
+	digest := computeDigest(activities, goal, goalGear, now)
+	if digest.Remaining == 0 {
+		return nil
+	}
+
+	yearStart := time.Date(today.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	yearEnd := yearStart.AddDate(1, 0, 0)
+	elapsed := today.Sub(yearStart).Hours() / yearEnd.Sub(yearStart).Hours()
+	nudge := &Nudge{
+		Behind:          goal*elapsed - digest.YearDistance,
+		RequiredPerWeek: digest.RequiredPerWeek,
+	}
+
+	var lastRide time.Time
+	for _, activity := range activities {
+		day := localDay(activity.StartDateLocal)
+		if !day.After(today) && day.After(lastRide) {
+			lastRide = day
+		}
+	}
+	if !lastRide.IsZero() {
+		nudge.InactiveDays = int(today.Sub(lastRide).Hours() / 24)
+	} else {
+		nudge.InactiveDays = int(today.Sub(yearStart).Hours() / 24)
+		nudge.NoRides = true
+	}
+
+	behind := settings.Threshold > 0 && nudge.Behind > settings.Threshold
+	inactive := settings.InactiveDays > 0 && nudge.InactiveDays >= settings.InactiveDays
+	if !behind && !inactive {
+		return nil
+	}
+	return nudge
+}
+
+// nudgeJob checks the pace of every athlete and sends "falling behind" nudges
+func nudgeJob() {
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for _, athleteID := range athleteIDs {
+		err = nudgeAthlete(athleteID, time.Now())
+		if err != nil {
+			Logger.Printf("failed to nudge athlete %d: %s\n", athleteID, err)
+		}
+	}
+}
+
+// nudgeAthlete sends a nudge to the athlete if the athlete is off pace and
+// wasn't nudged recently
+func nudgeAthlete(athleteID int, now time.Time) error {
+	notifiers, err := athleteNotifiers(athleteID, NotifyBehind)
+	if err != nil {
+		return err
+	}
+	if len(notifiers) == 0 {
+		return nil
+	}
+	settings, err := GetNudgeSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	if now.Sub(settings.LastNudge) < NudgeInterval {
+		return nil
+	}
+
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return err
+	}
+	goal, err := GetGoal(athleteID, now.Year())
+	if err != nil {
+		return err
+	}
+	goalGear, err := GetGoalGear(athleteID, now.Year())
+	if err != nil {
+		return err
+	}
+	nudge := computeNudge(activities, goal, goalGear, settings, now.In(athleteLocation(activities)))
+	if nudge == nil {
+		return nil
+	}
+
+	message, err := renderTextTemplate("notification_behind.txt", nudge)
+	if err != nil {
+		return err
+	}
+	err = notifyAthlete(athleteID, &Notification{
+		Kind:    NotifyBehind,
+		Title:   fmt.Sprintf("Ride %.0f km per week to reach your goal", nudge.RequiredPerWeek/1000),
+		Message: strings.TrimSpace(message),
+		Link:    "https://" + rootDomain,
+	})
+	if err != nil {
+		return err
+	}
+	Logger.Printf("nudged athlete %d: %.0f m behind, %d days without rides\n", athleteID, nudge.Behind, nudge.InactiveDays)
+	settings.LastNudge = now.UTC()
+	return SetNudgeSettings(athleteID, settings)
+}
+
+// updateNudgeSettings saves nudge thresholds from the submitted account form
+func updateNudgeSettings(athleteID int, r *http.Request) error {
+	settings, err := GetNudgeSettings(athleteID)
+	if err != nil {
+		return err
+	}
+	threshold, err := strconv.ParseFloat(r.FormValue("threshold"), 64)
+	if err != nil {
+		return err
+	}
+	inactiveDays, err := strconv.Atoi(r.FormValue("inactiveDays"))
+	if err != nil {
+		return err
+	}
+	if threshold < 0 || inactiveDays < 0 {
+		return fmt.Errorf("threshold and days can't be negative")
+	}
+	settings.Threshold = threshold * 1000
+	settings.InactiveDays = inactiveDays
+	return SetNudgeSettings(athleteID, settings)
+}
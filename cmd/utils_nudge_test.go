@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_computeNudge(t *testing.T) {
+	// July 2nd is the middle of the year
+	now := time.Date(2023, time.July, 2, 12, 0, 0, 0, time.UTC)
+	settings := &NudgeSettings{Threshold: 100000, InactiveDays: 7}
+	activities := []Activity{
+		{Distance: 300000, StartDateLocal: time.Date(2023, time.March, 1, 8, 0, 0, 0, time.UTC)},
+		{Distance: 50000, StartDateLocal: time.Date(2023, time.June, 30, 8, 0, 0, 0, time.UTC)},
+	}
+
+	nudge := computeNudge(activities, 1000000, nil, settings, now)
+	if nudge == nil {
+		t.Fatal("expected a nudge for the athlete 150 km behind")
+	}
+	if nudge.Behind < 145000 || nudge.Behind > 155000 || nudge.InactiveDays != 2 {
+		t.Errorf("expected ~150 km behind and 2 inactive days, got %+v", nudge)
+	}
+	// 650 km in 26 weeks
+	if nudge.RequiredPerWeek < 24000 || nudge.RequiredPerWeek > 26000 {
+		t.Errorf("expected ~25 km per week, got %f", nudge.RequiredPerWeek)
+	}
+
+	if computeNudge(activities, 600000, nil, settings, now) != nil {
+		t.Errorf("expected no nudge for the athlete on schedule")
+	}
+
+	inactive := computeNudge(activities, 600000, nil, settings, now.AddDate(0, 0, 10))
+	if inactive == nil || inactive.InactiveDays != 12 {
+		t.Errorf("expected a nudge after 12 days without rides, got %+v", inactive)
+	}
+
+	none := computeNudge(nil, 600000, nil, settings, now)
+	if none == nil || !none.NoRides || none.InactiveDays != 182 {
+		t.Errorf("expected a nudge for the athlete without rides, got %+v", none)
+	}
+
+	if computeNudge(activities, 300000, nil, settings, now.AddDate(0, 0, 10)) != nil {
+		t.Errorf("expected no nudge after the goal is achieved")
+	}
+}
+
+func Test_nudgeAthlete(t *testing.T) {
+	setupTestDB(t)
+	notifications := 0
+	var message string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		notifications++
+		body, _ := io.ReadAll(r.Body)
+		message = string(body)
+	}))
+	defer server.Close()
+	setNotifyClient(t, server.Client())
+
+	now := time.Date(2023, time.October, 18, 12, 0, 0, 0, time.UTC)
+	err := SetGoal(1, now.Year(), 100000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetPushSettings(1, &PushSettings{Service: "ntfy", URL: server.URL, Behind: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		err = nudgeAthlete(1, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if notifications != 1 {
+		t.Errorf("expected exactly one nudge, got %d", notifications)
+	}
+	if !strings.Contains(message, "You haven't recorded any rides yet.") || strings.Contains(message, "Your last ride") {
+		t.Errorf("unexpected nudge for the athlete without rides:\n%s", message)
+	}
+	settings, _ := GetNudgeSettings(1)
+	if settings.LastNudge.IsZero() {
+		t.Errorf("expected the time of the nudge to be saved")
+	}
+}
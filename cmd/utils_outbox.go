@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// OutboxEntry is the rendered description waiting for the athlete's approval
+// in the preview mode
+type OutboxEntry struct {
+	ActivityID int       `json:"activity_id"`
+	Name       string    `json:"name"`
+	StartDate  time.Time `json:"start_date"`
+	// Original is the description of the activity when it was processed
+	Original    string    `json:"original"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// applyOutboxEntry updates the activity with the description from the outbox
+// Notes:
+//   - the description is applied as it was rendered, so edits made on Strava
+//     after the activity was processed are overwritten
+func applyOutboxEntry(athleteID int, accessToken string, entry *OutboxEntry) error {
+	err := annotateActivity(athleteID, accessToken, entry.ActivityID, entry.Original, entry.Description)
+	if err != nil {
+		return err
+	}
+	return DeleteOutboxEntry(athleteID, entry.ActivityID)
+}
+
+// updateOutbox applies or discards descriptions from the submitted account
+// form. Applies all descriptions if the activity isn't specified. Discarded
+// activities are never annotated again, like undone ones
+func updateOutbox(athleteID int, r *http.Request) error {
+	entries, err := GetOutbox(athleteID)
+	if err != nil {
+		return err
+	}
+	if r.FormValue("activityId") != "" {
+		activityID, err := strconv.Atoi(r.FormValue("activityId"))
+		if err != nil {
+			return err
+		}
+		entry, err := GetOutboxEntry(athleteID, activityID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			return fmt.Errorf("activity %d is not in the outbox", activityID)
+		}
+		entries = []OutboxEntry{*entry}
+	}
+
+	switch r.FormValue("action") {
+	case "applyOutbox":
+		if len(entries) == 0 {
+			return nil
+		}
+		accessToken, err := RefreshAccessToken(athleteID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			err = applyOutboxEntry(athleteID, accessToken, &entry)
+			if err != nil {
+				return err
+			}
+		}
+	case "discardOutbox":
+		for _, entry := range entries {
+			err = DeleteOutboxEntry(athleteID, entry.ActivityID)
+			if err != nil {
+				return err
+			}
+			err = SetActivityReverted(athleteID, entry.ActivityID)
+			if err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown action %s", r.FormValue("action"))
+	}
+	return nil
+}
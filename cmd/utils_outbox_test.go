@@ -0,0 +1,112 @@
+package cmd
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_outbox(t *testing.T) {
+	setupTestDB(t)
+	for _, entry := range []OutboxEntry{
+		{ActivityID: 10, StartDate: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC), Description: "first"},
+		{ActivityID: 11, StartDate: time.Date(2023, time.May, 2, 0, 0, 0, 0, time.UTC), Description: "second"},
+		{ActivityID: 10, StartDate: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC), Description: "first again"},
+	} {
+		err := SetOutboxEntry(1, &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := GetOutbox(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ActivityID != 11 || entries[1].Description != "first again" {
+		t.Errorf("unexpected outbox %+v", entries)
+	}
+
+	err = DeleteOutboxEntry(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := GetOutboxEntry(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry != nil {
+		t.Errorf("expected the entry to be deleted")
+	}
+}
+
+func Test_updateOutbox_discard(t *testing.T) {
+	setupTestDB(t)
+	for _, id := range []int{10, 11, 12} {
+		err := SetOutboxEntry(1, &OutboxEntry{ActivityID: id})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	post := func(form url.Values) error {
+		r := httptest.NewRequest("POST", "/account", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return updateOutbox(1, r)
+	}
+
+	err := post(url.Values{"action": {"discardOutbox"}, "activityId": {"11"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := GetOutbox(1)
+	if len(entries) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(entries))
+	}
+	discarded, _ := IsActivityReverted(1, 11)
+	kept, _ := IsActivityReverted(1, 10)
+	if !discarded || kept {
+		t.Errorf("expected only the discarded activity to be never annotated again")
+	}
+
+	err = post(url.Values{"action": {"discardOutbox"}, "activityId": {"11"}})
+	if err == nil {
+		t.Errorf("expected an error for the activity which is not in the outbox")
+	}
+
+	err = post(url.Values{"action": {"discardOutbox"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ = GetOutbox(1)
+	if len(entries) != 0 {
+		t.Errorf("expected empty outbox, got %d entries", len(entries))
+	}
+}
+
+func Test_reconcileAthletes_skips_outbox(t *testing.T) {
+	setupTestDB(t)
+	defer drainAnnotationQueue()
+	err := SetOutboxEntry(1, &OutboxEntry{ActivityID: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Discarded in the preview
+	err = SetActivityReverted(1, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetch := func(athleteID int, after time.Time) ([]Activity, error) {
+		return []Activity{{ID: 10}, {ID: 11}, {ID: 12}}, nil
+	}
+
+	enqueued, err := reconcileAthletes(time.Now(), time.Hour, 100, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := drainAnnotationQueue()
+	if enqueued != 1 || len(requests) != 1 || requests[0].ActivityID != 11 {
+		t.Errorf("expected only activity 11 to be enqueued, got %+v", requests)
+	}
+}
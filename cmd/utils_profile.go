@@ -0,0 +1,218 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// YearSummary contains cycling statistics of the athlete for one year
+// Notes:
+//   - all distance is in meters
+//   - `Goal` is 0 when the athlete didn't set a goal for the year
+//   - `GoalDistance` is the distance which counts towards the goal
+type YearSummary struct {
+	Year         int
+	Goal         float64
+	GoalDistance float64
+	Distance     float64
+	Elevation    float64
+	Rides        int
+	Months       [12]float64
+	LongestRide  Activity
+}
+
+// Achieved reports whether the athlete reached the goal of the year
+func (s *YearSummary) Achieved() bool {
+	return s.Goal > 0 && s.GoalDistance >= s.Goal
+}
+
+// Progress returns progress towards the goal in percents
+func (s *YearSummary) Progress() float64 {
+	if s.Goal == 0 {
+		return 0
+	}
+	return s.GoalDistance / s.Goal * 100
+}
+
+// summarizeYears groups activities by year of their local start date. The
+// most recent year comes first
+func summarizeYears(activities []Activity) []*YearSummary {
+	years := map[int]*YearSummary{}
+	for _, activity := range activities {
+		year := activity.StartDateLocal.Year()
+		summary, ok := years[year]
+		if !ok {
+			summary = &YearSummary{Year: year}
+			years[year] = summary
+		}
+		summary.Distance += activity.Distance
+		summary.GoalDistance += activity.Distance
+		summary.Elevation += activity.TotalElevationGain
+		summary.Rides++
+		summary.Months[activity.StartDateLocal.Month()-1] += activity.Distance
+		if activity.Distance > summary.LongestRide.Distance {
+			summary.LongestRide = activity
+		}
+	}
+
+	summaries := make([]*YearSummary, 0, len(years))
+	for _, summary := range years {
+		summaries = append(summaries, summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Year > summaries[j].Year
+	})
+	return summaries
+}
+
+// getYearSummaries returns year by year archive of goal vs. achieved for the
+// athlete, including the current year
+func getYearSummaries(athleteID int) ([]*YearSummary, error) {
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	currentYear := time.Now().Year()
+	goals, err := GetGoals(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	// The goal for the current year may be rolled over, but not saved yet
+	goal, err := GetGoal(athleteID, currentYear)
+	if err == nil {
+		goals[currentYear] = goal
+	} else if !errors.Is(err, ErrGoalNotFound) {
+		return nil, err
+	}
+
+	summaries := summarizeYears(activities)
+	known := map[int]bool{}
+	for _, summary := range summaries {
+		known[summary.Year] = true
+	}
+	for year := range goals {
+		if !known[year] {
+			summaries = append(summaries, &YearSummary{Year: year})
+			known[year] = true
+		}
+	}
+	if !known[currentYear] {
+		summaries = append(summaries, &YearSummary{Year: currentYear})
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].Year > summaries[j].Year
+	})
+	for _, summary := range summaries {
+		summary.Goal = goals[summary.Year]
+		gearIDs, err := GetGoalGear(athleteID, summary.Year)
+		if err != nil {
+			return nil, err
+		}
+		if len(gearIDs) == 0 {
+			continue
+		}
+		summary.GoalDistance = 0
+		for _, activity := range activities {
+			if activity.StartDateLocal.Year() == summary.Year && matchesGear(activity, gearIDs) {
+				summary.GoalDistance += activity.Distance
+			}
+		}
+	}
+	return summaries, nil
+}
+
+// profileHandler renders the public profile page of the athlete and its
+// year-end summaries. The page is available only if the athlete opted in
+func profileHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	// Supported paths are /athlete/{id} and /athlete/{id}/summary/{year}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/athlete/"), "/")
+	athleteID, err := strconv.Atoi(parts[0])
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if len(parts) == 3 && parts[1] == "summary" {
+		year, err := strconv.Atoi(parts[2])
+		if err != nil {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		summaryHandler(w, r, athleteID, year)
+		return
+	}
+	if len(parts) != 1 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	settings, err := GetProfileSettings(athleteID)
+	if err != nil || !settings.Public {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	summaries, err := getYearSummaries(athleteID)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderHTML(w, "templates/profile.html", map[string]interface{}{
+		"Name":      GetAthleteName(athleteID),
+		"Settings":  settings,
+		"Summaries": summaries,
+	})
+}
+
+// htmlFuncMap contains helpers available in all HTML templates
+var htmlFuncMap = template.FuncMap{
+	"toKm": func(meters float64) string {
+		return fmt.Sprintf("%.2f", meters/1000)
+	},
+	"toFixedTwo": func(f float64) string {
+		return fmt.Sprintf("%.2f", f)
+	},
+	"monthName": func(month int) string {
+		return time.Month(month + 1).String()[:3]
+	},
+}
+
+// renderHTML renders HTML template from the embedded filesystem
+func renderHTML(w http.ResponseWriter, name string, data interface{}) {
+	tmplContent, err := TemplatesStorage.ReadFile(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the template content
+	tmpl, err := template.New("template").Funcs(htmlFuncMap).Parse(string(tmplContent))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Render to the buffer first, so that errors are not mixed with the page
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_summarizeYears(t *testing.T) {
+	activities := []Activity{
+		{ID: 1, Distance: 10000, TotalElevationGain: 100, StartDateLocal: time.Date(2022, time.March, 1, 10, 0, 0, 0, time.UTC)},
+		{ID: 2, Distance: 30000, TotalElevationGain: 50, StartDateLocal: time.Date(2023, time.January, 5, 10, 0, 0, 0, time.UTC)},
+		{ID: 3, Distance: 20000, TotalElevationGain: 10, StartDateLocal: time.Date(2023, time.January, 7, 10, 0, 0, 0, time.UTC)},
+		{ID: 4, Distance: 5000, StartDateLocal: time.Date(2023, time.May, 7, 10, 0, 0, 0, time.UTC)},
+	}
+
+	summaries := summarizeYears(activities)
+	if len(summaries) != 2 {
+		t.Fatalf("expected 2 years, got %d", len(summaries))
+	}
+
+	current := summaries[0]
+	if current.Year != 2023 || current.Rides != 3 || current.Distance != 55000 || current.Elevation != 60 {
+		t.Errorf("unexpected summary: %+v", current)
+	}
+	if current.Months[0] != 50000 || current.Months[4] != 5000 {
+		t.Errorf("unexpected monthly breakdown: %v", current.Months)
+	}
+	if current.LongestRide.ID != 2 {
+		t.Errorf("expected longest ride 2, got %d", current.LongestRide.ID)
+	}
+
+	current.Goal = 50000
+	if !current.Achieved() {
+		t.Error("expected goal to be achieved")
+	}
+	if summaries[1].Achieved() {
+		t.Error("year without goal can't be achieved")
+	}
+}
@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ReconcileListCost is the estimated number of Strava requests needed to list
+// recent activities of the athlete
+const ReconcileListCost = 2
+
+// ReconcileAnnotationCost is the estimated number of Strava requests needed to
+// annotate one activity
+const ReconcileAnnotationCost = 4
+
+// ReconcileMaxAttempts is the number of failed attempts after which the
+// reconciliation stops retrying the activity or listing activities of the
+// athlete. Webhook events are still processed
+const ReconcileMaxAttempts = 3
+
+// handleActivityEvent processes the activity from the webhook event. Events
+// are processed right away, so athletes see the description shortly after
+// the upload
+var handleActivityEvent = func(activityID int, athleteID int) {
+	go addCommentToActivity(activityID, athleteID)
+}
+
+// AnnotationRequest is an activity waiting for its description to be updated
+type AnnotationRequest struct {
+	ActivityID int
+	AthleteID  int
+}
+
+// annotationQueue contains activities missed by webhooks. Activities are
+// annotated one by one to spread Strava requests of the reconciliation over
+// time
+var annotationQueue = make(chan AnnotationRequest, 1000)
+
+// annotationPending contains IDs of activities which are in the queue
+var annotationPending sync.Map
+
+// enqueueAnnotation adds the activity to the annotation queue. Returns false
+// if the activity is already queued or the queue is full
+func enqueueAnnotation(activityID int, athleteID int) bool {
+	_, queued := annotationPending.LoadOrStore(activityID, true)
+	if queued {
+		return false
+	}
+	select {
+	case annotationQueue <- AnnotationRequest{ActivityID: activityID, AthleteID: athleteID}:
+		return true
+	default:
+		annotationPending.Delete(activityID)
+		Logger.Printf("annotation queue is full, activity %d is dropped\n", activityID)
+		return false
+	}
+}
+
+// runAnnotationWorker annotates queued activities. It never returns, so it is
+// supposed to be started in a goroutine
+func runAnnotationWorker() {
+	for request := range annotationQueue {
+		annotationPending.Delete(request.ActivityID)
+		addCommentToActivity(request.ActivityID, request.AthleteID)
+	}
+}
+
+// ReconcileFetcher returns cycling activities of the athlete which started
+// after `after`
+type ReconcileFetcher func(athleteID int, after time.Time) ([]Activity, error)
+
+// fetchRecentActivities fetches activities from Strava
+func fetchRecentActivities(athleteID int, after time.Time) ([]Activity, error) {
+	accessToken, err := RefreshAccessToken(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	activities, err := getActivitiesBetween(accessToken, after, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	return *activities, nil
+}
+
+// reconcileJob enqueues activities whose webhooks were missed
+func reconcileJob() {
+	enqueued, err := reconcileAthletes(time.Now(), rootReconcileLookback, rootReconcileBudget, fetchRecentActivities)
+	if err != nil {
+		Logger.Println(err)
+	}
+	Logger.Printf("reconciliation enqueued %d activities\n", enqueued)
+}
+
+// reconcileAthletes lists recent activities of the athletes and enqueues the
+// ones which weren't annotated. Returns the number of enqueued activities
+// Notes:
+//   - only activities which started within `lookback` are checked
+//   - `budget` is the number of Strava requests the run can spend. Athletes
+//     who were reconciled least recently go first, so the next run continues
+//     where the budget ran out
+func reconcileAthletes(now time.Time, lookback time.Duration, budget int, fetch ReconcileFetcher) (int, error) {
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		return 0, err
+	}
+	reconciledAt := map[int]time.Time{}
+	for _, athleteID := range athleteIDs {
+		var last time.Time
+		_, err = getAthleteValue(athleteID, "reconciledAt", &last)
+		if err != nil {
+			return 0, err
+		}
+		reconciledAt[athleteID] = last
+	}
+	sort.SliceStable(athleteIDs, func(i, j int) bool {
+		return reconciledAt[athleteIDs[i]].Before(reconciledAt[athleteIDs[j]])
+	})
+
+	enqueued := 0
+	for _, athleteID := range athleteIDs {
+		// Athletes who revoked access fail every time
+		failures := 0
+		_, err = getAthleteValue(athleteID, "reconcileFailures", &failures)
+		if err != nil {
+			return enqueued, err
+		}
+		if failures >= ReconcileMaxAttempts {
+			continue
+		}
+		if budget < ReconcileListCost {
+			Logger.Println("reconciliation budget is exhausted")
+			break
+		}
+		budget -= ReconcileListCost
+		activities, err := fetch(athleteID, now.Add(-lookback))
+		if err != nil {
+			Logger.Printf("failed to reconcile athlete %d: %s\n", athleteID, err)
+			err = setAthleteValue(athleteID, "reconcileFailures", failures+1)
+			if err != nil {
+				return enqueued, err
+			}
+			continue
+		}
+		if failures > 0 {
+			err = setAthleteValue(athleteID, "reconcileFailures", 0)
+			if err != nil {
+				return enqueued, err
+			}
+		}
+
+		exhausted := false
+		for _, activity := range activities {
+			// Strava lists activities without descriptions. Activities which
+			// already have the signature are recorded as annotated when
+			// they are processed
+			annotated, err := IsActivityAnnotated(athleteID, activity.ID)
+			if err != nil {
+				return enqueued, err
+			}
+			if annotated {
+				continue
+			}
+			// Undone and discarded activities are never annotated again
+			reverted, err := IsActivityReverted(athleteID, activity.ID)
+			if err != nil {
+				return enqueued, err
+			}
+			if reverted {
+				continue
+			}
+			// Activities waiting for approval in the preview mode aren't missed
+			pending, err := GetOutboxEntry(athleteID, activity.ID)
+			if err != nil {
+				return enqueued, err
+			}
+			if pending != nil {
+				continue
+			}
+			// Activities which fail every time, e.g. rides without distance
+			attempts, err := GetActivityFailures(athleteID, activity.ID)
+			if err != nil {
+				return enqueued, err
+			}
+			if attempts >= ReconcileMaxAttempts {
+				continue
+			}
+			if budget < ReconcileAnnotationCost {
+				exhausted = true
+				break
+			}
+			if enqueueAnnotation(activity.ID, athleteID) {
+				Logger.Printf("activity %d of athlete %d was missed, enqueued\n", activity.ID, athleteID)
+				budget -= ReconcileAnnotationCost
+				enqueued++
+			}
+		}
+		// Athletes with skipped activities are checked first next time
+		if exhausted {
+			break
+		}
+		err = setAthleteValue(athleteID, "reconciledAt", now.UTC())
+		if err != nil {
+			return enqueued, err
+		}
+	}
+	return enqueued, nil
+}
@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// drainAnnotationQueue returns and removes all queued activities
+func drainAnnotationQueue() []AnnotationRequest {
+	requests := []AnnotationRequest{}
+	for {
+		select {
+		case request := <-annotationQueue:
+			annotationPending.Delete(request.ActivityID)
+			requests = append(requests, request)
+		default:
+			return requests
+		}
+	}
+}
+
+func Test_enqueueAnnotation(t *testing.T) {
+	defer drainAnnotationQueue()
+	if !enqueueAnnotation(1, 1) {
+		t.Errorf("expected activity to be enqueued")
+	}
+	if enqueueAnnotation(1, 1) {
+		t.Errorf("expected duplicate activity to be skipped")
+	}
+}
+
+func Test_reconcileAthletes(t *testing.T) {
+	setupTestDB(t)
+	defer drainAnnotationQueue()
+	err := SaveAuthData(2, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SetActivityAnnotated(1, 11)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2023, time.October, 18, 12, 0, 0, 0, time.UTC)
+	var lookbacks []time.Time
+	fetch := func(athleteID int, after time.Time) ([]Activity, error) {
+		lookbacks = append(lookbacks, after)
+		if athleteID == 1 {
+			return []Activity{
+				{ID: 10},
+				{ID: 11},
+			}, nil
+		}
+		return []Activity{{ID: 20}, {ID: 21}}, nil
+	}
+
+	// The budget is enough for athlete 1 and one activity of athlete 2
+	budget := 2*ReconcileListCost + 2*ReconcileAnnotationCost
+	enqueued, err := reconcileAthletes(now, 48*time.Hour, budget, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := drainAnnotationQueue()
+	if enqueued != 2 || len(requests) != 2 || requests[0].ActivityID != 10 || requests[1].ActivityID != 20 {
+		t.Fatalf("expected activities 10 and 20 to be enqueued, got %d %+v", enqueued, requests)
+	}
+	if !lookbacks[0].Equal(now.Add(-48 * time.Hour)) {
+		t.Errorf("expected lookback of 48 hours, got %s", lookbacks[0])
+	}
+
+	// Athlete 2 ran out of budget, so the next run starts with them
+	lookbacks = nil
+	enqueued, err = reconcileAthletes(now.Add(time.Hour), 48*time.Hour, ReconcileListCost+ReconcileAnnotationCost, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests = drainAnnotationQueue()
+	if enqueued != 1 || len(lookbacks) != 1 || requests[0].AthleteID != 2 {
+		t.Errorf("expected athlete 2 to be reconciled first, got %+v", requests)
+	}
+}
+
+func Test_reconcileAthletes_failures(t *testing.T) {
+	setupTestDB(t)
+	defer drainAnnotationQueue()
+	err := SaveAuthData(2, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < ReconcileMaxAttempts; i++ {
+		_, err = AddActivityFailure(1, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Date(2023, time.October, 18, 12, 0, 0, 0, time.UTC)
+	fetches := map[int]int{}
+	fetch := func(athleteID int, after time.Time) ([]Activity, error) {
+		fetches[athleteID]++
+		if athleteID == 2 {
+			return nil, fmt.Errorf("authorization error")
+		}
+		return []Activity{{ID: 10}, {ID: 11}}, nil
+	}
+
+	for i := 0; i < ReconcileMaxAttempts+2; i++ {
+		_, err = reconcileAthletes(now.Add(time.Duration(i)*time.Hour), 48*time.Hour, 100, fetch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests := drainAnnotationQueue()
+		if len(requests) != 1 || requests[0].ActivityID != 11 {
+			t.Fatalf("expected only activity 11 to be retried, got %+v", requests)
+		}
+	}
+	if fetches[2] != ReconcileMaxAttempts {
+		t.Errorf("expected athlete 2 to be listed %d times, got %d", ReconcileMaxAttempts, fetches[2])
+	}
+
+	// Athlete 2 logs in again
+	err = SaveAuthData(2, &StravaResponseRefresh{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reconcileAthletes(now.Add(24*time.Hour), 48*time.Hour, 100, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drainAnnotationQueue()
+	if fetches[2] != ReconcileMaxAttempts+1 {
+		t.Errorf("expected athlete 2 to be listed again after the login")
+	}
+
+	err = DeleteActivityFailures(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, _ := GetActivityFailures(1, 10)
+	if attempts != 0 {
+		t.Errorf("expected failures to be forgotten, got %d", attempts)
+	}
+}
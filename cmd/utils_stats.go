@@ -0,0 +1,272 @@
+package cmd
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// Streaks contains riding consistency statistics of the athlete
+type Streaks struct {
+	// CurrentDaily is the number of consecutive days with rides, up to today
+	// or yesterday
+	CurrentDaily int `json:"current_daily"`
+	LongestDaily int `json:"longest_daily"`
+	// CurrentWeekly is the number of consecutive weeks with rides, up to this
+	// week or the previous one
+	CurrentWeekly int `json:"current_weekly"`
+	LongestWeekly int `json:"longest_weekly"`
+	// ActiveDays is the number of days with rides in the current year
+	ActiveDays    int `json:"active_days"`
+	RidesThisWeek int `json:"rides_this_week"`
+}
+
+// getStreaks calculates streaks of the athlete from the stored activities
+func getStreaks(athleteID int) (*Streaks, error) {
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	return computeStreaks(activities, time.Now().In(athleteLocation(activities))), nil
+}
+
+// athleteLocation returns time zone of the athlete based on the time zone of
+// the most recent activity. Activities must be sorted by start date
+func athleteLocation(activities []Activity) *time.Location {
+	if len(activities) == 0 {
+		return time.UTC
+	}
+	// Strava time zone looks like "(GMT+01:00) Europe/Amsterdam"
+	fields := strings.Fields(activities[len(activities)-1].Timezone)
+	if len(fields) == 0 {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(fields[len(fields)-1])
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// localDay returns midnight of the day of `t` in its own time zone as UTC
+// time, so that days can be compared and counted
+func localDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// startOfWeek returns Monday of the week of `day`
+func startOfWeek(day time.Time) time.Time {
+	weekday := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -weekday)
+}
+
+// computeStreaks calculates streaks from the local start dates of the
+// activities. `now` must be in athlete's time zone
+func computeStreaks(activities []Activity, now time.Time) *Streaks {
+	streaks := &Streaks{}
+	today := localDay(now)
+	thisWeek := startOfWeek(today)
+
+	days := map[time.Time]bool{}
+	weeks := map[time.Time]bool{}
+	for _, activity := range activities {
+		day := localDay(activity.StartDateLocal)
+		if day.After(today) {
+			continue
+		}
+		days[day] = true
+		weeks[startOfWeek(day)] = true
+		if !day.Before(thisWeek) {
+			streaks.RidesThisWeek++
+		}
+	}
+
+	for day := range days {
+		if day.Year() == today.Year() {
+			streaks.ActiveDays++
+		}
+	}
+
+	streaks.LongestDaily = longestStreak(days, 1)
+	streaks.LongestWeekly = longestStreak(weeks, 7)
+	streaks.CurrentDaily = currentStreak(days, today, 1)
+	streaks.CurrentWeekly = currentStreak(weeks, thisWeek, 7)
+	return streaks
+}
+
+// longestStreak returns the longest sequence of periods, `step` days apart
+func longestStreak(periods map[time.Time]bool, step int) int {
+	sorted := make([]time.Time, 0, len(periods))
+	for period := range periods {
+		sorted = append(sorted, period)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Before(sorted[j])
+	})
+
+	longest := 0
+	current := 0
+	for i, period := range sorted {
+		if i > 0 && sorted[i-1].AddDate(0, 0, step).Equal(period) {
+			current++
+		} else {
+			current = 1
+		}
+		if current > longest {
+			longest = current
+		}
+	}
+	return longest
+}
+
+// currentStreak returns the length of the sequence of periods, `step` days
+// apart, which ends at `last` period. The streak is not broken yet if there
+// is no activity in the `last` period
+func currentStreak(periods map[time.Time]bool, last time.Time, step int) int {
+	if !periods[last] {
+		last = last.AddDate(0, 0, -step)
+	}
+	streak := 0
+	for periods[last] {
+		streak++
+		last = last.AddDate(0, 0, -step)
+	}
+	return streak
+}
+
+// Eddington contains Eddington numbers of the athlete: E rides of at least E km
+type Eddington struct {
+	Yearly int `json:"yearly"`
+	// YearlyNeeded is the number of rides of Yearly+1 km needed to increase
+	// the yearly Eddington number
+	YearlyNeeded  int `json:"yearly_needed"`
+	AllTime       int `json:"all_time"`
+	AllTimeNeeded int `json:"all_time_needed"`
+	// Increased is true if the activity increased one of the numbers
+	Increased bool `json:"increased"`
+}
+
+// YearlyNext returns the next yearly Eddington number
+func (e *Eddington) YearlyNext() int {
+	return e.Yearly + 1
+}
+
+// AllTimeNext returns the next all-time Eddington number
+func (e *Eddington) AllTimeNext() int {
+	return e.AllTime + 1
+}
+
+// RideHistory contains distances in meters of rides of the athlete which
+// started before `Year`. Only activities of the current year are stored, so
+// older rides are listed on Strava once a year for the all-time numbers
+type RideHistory struct {
+	Year      int       `json:"year"`
+	Distances []float64 `json:"distances"`
+}
+
+// refreshRideHistory lists rides of the previous years on Strava unless they
+// were already listed this year
+func refreshRideHistory(athleteID int, accessToken string, now time.Time) error {
+	history, err := GetRideHistory(athleteID)
+	if err != nil {
+		return err
+	}
+	if history.Year == now.Year() {
+		return nil
+	}
+	startOfYear := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	activities, err := getActivitiesBetween(accessToken, time.Unix(0, 0), startOfYear)
+	if err != nil {
+		return err
+	}
+	history = &RideHistory{Year: now.Year(), Distances: []float64{}}
+	for _, activity := range *activities {
+		history.Distances = append(history.Distances, activity.Distance)
+	}
+	return SetRideHistory(athleteID, history)
+}
+
+// getEddington calculates Eddington numbers of the athlete from the stored
+// activities and the ride history. `activityID` is the activity which is
+// checked for increasing the numbers
+// Notes:
+//   - until the history is listed on Strava, the all-time number counts only
+//     stored activities
+func getEddington(athleteID int, activityID int) (*Eddington, error) {
+	activities, err := GetActivities(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	history, err := GetRideHistory(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	year := time.Now().Year()
+	if history.Year != year {
+		return computeEddington(activities, nil, year, activityID), nil
+	}
+	// Stored activities of previous years are part of the history
+	current := []Activity{}
+	for _, activity := range activities {
+		if activity.StartDateLocal.Year() == year {
+			current = append(current, activity)
+		}
+	}
+	return computeEddington(current, history.Distances, year, activityID), nil
+}
+
+// computeEddington calculates yearly and all-time Eddington numbers.
+// `history` contains distances of rides which are not in `activities`
+func computeEddington(activities []Activity, history []float64, year int, activityID int) *Eddington {
+	var yearly, yearlyBefore []float64
+	allTime := append([]float64{}, history...)
+	allTimeBefore := append([]float64{}, history...)
+	for _, activity := range activities {
+		isYearly := activity.StartDateLocal.Year() == year
+		allTime = append(allTime, activity.Distance)
+		if isYearly {
+			yearly = append(yearly, activity.Distance)
+		}
+		if activity.ID == activityID {
+			continue
+		}
+		allTimeBefore = append(allTimeBefore, activity.Distance)
+		if isYearly {
+			yearlyBefore = append(yearlyBefore, activity.Distance)
+		}
+	}
+
+	result := &Eddington{}
+	result.Yearly, result.YearlyNeeded = eddingtonNumber(yearly)
+	result.AllTime, result.AllTimeNeeded = eddingtonNumber(allTime)
+	previousYearly, _ := eddingtonNumber(yearlyBefore)
+	previousAllTime, _ := eddingtonNumber(allTimeBefore)
+	result.Increased = result.Yearly > previousYearly || result.AllTime > previousAllTime
+	return result
+}
+
+// eddingtonNumber returns the largest number E such that there are at least E
+// rides of at least E km, and the number of rides of at least E+1 km which are
+// missing to reach E+1. Distances are in meters
+func eddingtonNumber(distances []float64) (int, int) {
+	sorted := make([]float64, len(distances))
+	copy(sorted, distances)
+	sort.Sort(sort.Reverse(sort.Float64Slice(sorted)))
+
+	number := 0
+	for i, distance := range sorted {
+		if distance/1000 < float64(i+1) {
+			break
+		}
+		number = i + 1
+	}
+
+	longEnough := 0
+	for _, distance := range sorted {
+		if distance/1000 < float64(number+1) {
+			break
+		}
+		longEnough++
+	}
+	return number, number + 1 - longEnough
+}
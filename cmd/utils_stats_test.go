@@ -0,0 +1,128 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+)
+
+func activityOn(year int, month time.Month, day int) Activity {
+	return Activity{StartDateLocal: time.Date(year, month, day, 23, 30, 0, 0, time.UTC)}
+}
+
+func Test_computeStreaks(t *testing.T) {
+	// Wednesday
+	now := time.Date(2023, time.March, 15, 8, 0, 0, 0, time.UTC)
+	activities := []Activity{
+		activityOn(2022, time.December, 30),
+		activityOn(2023, time.February, 20),
+		activityOn(2023, time.February, 21),
+		activityOn(2023, time.February, 22),
+		activityOn(2023, time.February, 27),
+		activityOn(2023, time.March, 6),
+		activityOn(2023, time.March, 13),
+		activityOn(2023, time.March, 14),
+		activityOn(2023, time.March, 14),
+	}
+
+	streaks := computeStreaks(activities, now)
+	expected := Streaks{
+		CurrentDaily:  2,
+		LongestDaily:  3,
+		CurrentWeekly: 4,
+		LongestWeekly: 4,
+		ActiveDays:    7,
+		RidesThisWeek: 3,
+	}
+	if *streaks != expected {
+		t.Errorf("expected %+v, got %+v", expected, *streaks)
+	}
+}
+
+func Test_computeStreaks_broken(t *testing.T) {
+	now := time.Date(2023, time.March, 15, 8, 0, 0, 0, time.UTC)
+	activities := []Activity{
+		activityOn(2023, time.March, 1),
+		activityOn(2023, time.March, 13),
+	}
+
+	streaks := computeStreaks(activities, now)
+	if streaks.CurrentDaily != 0 || streaks.CurrentWeekly != 1 || streaks.LongestWeekly != 1 {
+		t.Errorf("unexpected streaks %+v", *streaks)
+	}
+}
+
+func Test_athleteLocation(t *testing.T) {
+	location := athleteLocation([]Activity{{Timezone: "(GMT+01:00) Europe/Amsterdam"}})
+	if location.String() != "Europe/Amsterdam" {
+		t.Errorf("unexpected location %s", location)
+	}
+	if athleteLocation(nil) != time.UTC {
+		t.Error("expected UTC for no activities")
+	}
+}
+
+func Test_eddingtonNumber(t *testing.T) {
+	number, needed := eddingtonNumber([]float64{5000, 3500, 3000, 2500, 1000, 20000})
+	if number != 3 || needed != 2 {
+		t.Errorf("expected 3 and 2, got %d and %d", number, needed)
+	}
+
+	number, needed = eddingtonNumber(nil)
+	if number != 0 || needed != 1 {
+		t.Errorf("expected 0 and 1, got %d and %d", number, needed)
+	}
+}
+
+func Test_computeEddington_increased(t *testing.T) {
+	activities := []Activity{
+		{ID: 1, Distance: 3000, StartDateLocal: time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, Distance: 2000, StartDateLocal: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 3, Distance: 2500, StartDateLocal: time.Date(2023, time.May, 2, 0, 0, 0, 0, time.UTC)},
+	}
+
+	eddington := computeEddington(activities, nil, 2023, 3)
+	if eddington.Yearly != 2 || eddington.AllTime != 2 || eddington.YearlyNeeded != 3 || eddington.AllTimeNeeded != 2 {
+		t.Errorf("unexpected Eddington numbers %+v", *eddington)
+	}
+	if !eddington.Increased {
+		t.Error("expected activity 3 to increase Eddington number")
+	}
+
+	eddington = computeEddington(activities, nil, 2023, 1)
+	if eddington.Increased {
+		t.Error("activity 1 didn't increase Eddington number")
+	}
+}
+
+func Test_getEddington_history(t *testing.T) {
+	setupTestDB(t)
+	year := time.Now().Year()
+	err := SaveActivities(1, []Activity{
+		{ID: 1, Distance: 3000, StartDateLocal: time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		// Stored for the year-over-year comparison and part of the history
+		{ID: 2, Distance: 3000, StartDateLocal: time.Date(year-1, time.May, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eddington, err := getEddington(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eddington.Yearly != 1 || eddington.AllTime != 2 {
+		t.Errorf("expected stored activities without the history, got %+v", *eddington)
+	}
+
+	err = SetRideHistory(1, &RideHistory{Year: year, Distances: []float64{3000, 4000, 5000}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eddington, err = getEddington(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eddington.Yearly != 1 || eddington.AllTime != 3 || eddington.AllTimeNeeded != 2 {
+		t.Errorf("expected the history in the all-time number, got %+v", *eddington)
+	}
+}
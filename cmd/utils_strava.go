@@ -6,12 +6,17 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
+	"github.com/jellydator/ttlcache/v3"
 	"golang.org/x/exp/slices"
 )
 
+// StravaAuthorizeURL is the URL where athletes authorize the application
+const StravaAuthorizeURL = "https://www.strava.com/oauth/authorize"
+
 // StravaAuthURL is the URL of oauth endpoint
 const StravaAuthURL = "https://www.strava.com/oauth/token"
 
@@ -20,9 +25,13 @@ const StravaUploadURL = "https://www.strava.com/api/v3/uploads"
 
 const StravaWebhookSubscribeURL = "https://www.strava.com/api/v3/push_subscriptions"
 
+const StravaAthleteURL = "https://www.strava.com/api/v3/athlete"
 const StravaListActivitiesURL = "https://www.strava.com/api/v3/athlete/activities"
 const StravaUpdateActivityURL = "https://www.strava.com/api/v3/activities"
 
+// Signature is added to the end of annotated activity descriptions
+const Signature = "-- https://go-cycle.yauhen.cc"
+
 // List of activities which are considered to be "cycling" activities
 var CyclingActivities = []string{
 	"GravelRide",
@@ -47,113 +56,299 @@ type StravaResponseAuth struct {
 
 // AthleteData is the `athlete` key in StravaResponse
 type AthleteData struct {
-	ID int `json:"id"`
+	ID        int    `json:"id"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
 }
 
 type Activity struct {
-	ID          int     `json:"id"`
-	SportType   string  `json:"sport_type"`
-	Distance    float64 `json:"distance"`
-	Description string  `json:"description"`
+	ID                 int       `json:"id"`
+	Name               string    `json:"name"`
+	SportType          string    `json:"sport_type"`
+	Distance           float64   `json:"distance"`
+	MovingTime         int       `json:"moving_time"`
+	TotalElevationGain float64   `json:"total_elevation_gain"`
+	StartDate          time.Time `json:"start_date"`
+	StartDateLocal     time.Time `json:"start_date_local"`
+	Timezone           string    `json:"timezone"`
+	GearID             string    `json:"gear_id"`
+	Description        string    `json:"description"`
+}
+
+// Gear is a bike of the athlete. `Distance` is the total distance of the bike
+// in meters as reported by Strava
+type Gear struct {
+	ID       string  `json:"id"`
+	Name     string  `json:"name"`
+	Distance float64 `json:"distance"`
 }
 
 type StravaWebhookData struct {
-	ObjectType string `json:"object_type"`
-	ObjectID   int    `json:"object_id"`
-	AspectType string `json:"aspect_type"`
-	OwnerID    int    `json:"owner_id"`
+	ObjectType     string `json:"object_type"`
+	ObjectID       int    `json:"object_id"`
+	AspectType     string `json:"aspect_type"`
+	OwnerID        int    `json:"owner_id"`
+	SubscriptionID int    `json:"subscription_id"`
 }
 
+// addCommentToActivity adds the progress block to the description of the
+// activity. Errors are logged
 func addCommentToActivity(activityID int, userID int) {
-	goal, err := GetGoal(userID)
+	err := processActivity(activityID, userID, false)
+	if err != nil {
+		Logger.Println(err)
+		attempts, err := AddActivityFailure(userID, activityID)
+		if err != nil {
+			Logger.Println(err)
+		} else if attempts >= ReconcileMaxAttempts {
+			Logger.Printf("activity %d of athlete %d failed %d times, reconciliation gives up\n", activityID, userID, attempts)
+		}
+		return
+	}
+	err = DeleteActivityFailures(userID, activityID)
+	if err != nil {
+		Logger.Println(err)
+	}
+}
+
+// processActivity renders the progress block of the activity and updates the
+// description or stores it in the outbox in the preview mode
+// Notes:
+//   - with `force` the activity is processed even if it was undone or already
+//     has the block. The block is rendered again from the original description
+//     and no webhooks or notifications are sent, because they were sent when
+//     the activity was processed first and totals have changed since
+func processActivity(activityID int, userID int, force bool) error {
+	// Strava sends update events for activities, so undone annotations would
+	// come back otherwise
+	reverted, err := IsActivityReverted(userID, activityID)
+	if err != nil {
+		return err
+	}
+	if reverted && !force {
+		Logger.Printf("annotation of activity %d was undone\n", activityID)
+		return nil
+	}
+
+	goal, err := GetGoal(userID, time.Now().Year())
 	if err != nil {
 		Logger.Println(err)
 		goal = 5000000
 	}
-	signature := "-- https://go-cycle.yauhen.cc"
+	signature := Signature
 	accessToken, err := RefreshAccessToken(userID)
 	if err != nil {
-		Logger.Println(err)
-		return
+		return err
 	}
 
 	activities, err := getYearActivities(accessToken)
 	if err != nil {
-		Logger.Println(err)
-		return
+		return err
 	}
 	Logger.Printf("found %d cycling activities\n", len(*activities))
 
+	err = SaveActivities(userID, *activities)
+	if err != nil {
+		Logger.Println(err)
+	}
+
+	// Goal can be restricted to some bikes only
+	goalGear, err := GetGoalGear(userID, time.Now().Year())
+	if err != nil {
+		Logger.Println(err)
+	}
+
 	totalDistance := 0.0
 	activityDistance := 0.0
-	activityDescription := ""
+	contributedDistance := 0.0
+	activityGearID := ""
+	var processedActivity Activity
 	for _, activity := range *activities {
-		totalDistance += activity.Distance
+		countsTowardsGoal := matchesGear(activity, goalGear)
+		if countsTowardsGoal {
+			totalDistance += activity.Distance
+		}
 		if activity.ID == activityID {
 			Logger.Printf("found matching activity %d\n", activity.ID)
 			activityDistance = activity.Distance
-			activityDescription = activity.Description
+			if countsTowardsGoal {
+				contributedDistance = activity.Distance
+			}
+			activityGearID = activity.GearID
+			processedActivity = activity
 			if !slices.Contains(CyclingActivities, activity.SportType) {
 				Logger.Printf("activity %d is not cycling\n", activityID)
-				return
+				return nil
 			}
 		}
 	}
 
 	if activityDistance == 0 {
-		Logger.Printf("activity %d not found\n", activityID)
-		return
+		return fmt.Errorf("activity %d not found", activityID)
+	}
+	// Strava lists activities without descriptions
+	detailed, err := fetchActivity(accessToken, activityID)
+	if err != nil {
+		return err
 	}
+	activityDescription := detailed.Description
 
 	Logger.Printf("total distance for user %d: %f\n", userID, totalDistance)
 	Logger.Printf("activity %d, distance: %f\n", activityID, activityDistance)
 	Logger.Printf("activity %d, description: %s\n", activityID, activityDescription)
 
 	if strings.Contains(activityDescription, signature) {
-		Logger.Printf("activity %d already has signature\n", activityID)
-		return
+		if !force {
+			Logger.Printf("activity %d already has signature\n", activityID)
+			return SetActivityAnnotated(userID, activityID)
+		}
+		original, found, err := GetOriginalDescription(userID, activityID)
+		if err != nil {
+			return err
+		}
+		if !found {
+			original, err = stripDescriptionBlock(activityDescription)
+			if err != nil {
+				return fmt.Errorf("activity %d: %s", activityID, err)
+			}
+		}
+		activityDescription = original
 	}
 
-	newDesc, err := renderDescription(goal, totalDistance, activityDistance, activityDescription, signature)
+	settings, err := GetDescriptionSettings(userID)
 	if err != nil {
 		Logger.Println(err)
-		return
 	}
-
-	// Update activity
-	data := struct {
-		Description string `json:"description"`
-	}{
-		Description: newDesc,
+	extra := map[string]interface{}{}
+	if settings.YearOverYear {
+		lastYearDistance, err := getPreviousYearDistance(userID, accessToken, time.Now(), goalGear)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["LastYear"] = time.Now().Year() - 1
+			extra["LastYearDifference"] = (totalDistance - lastYearDistance) / 1000
+		}
 	}
-	dataJson, err := json.Marshal(data)
-	if err != nil {
-		Logger.Println(err)
-		return
+	if settings.Streaks {
+		streaks, err := getStreaks(userID)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["Streaks"] = streaks
+		}
 	}
-	body := bytes.NewBuffer(dataJson)
-	req, err := http.NewRequest("PUT", StravaUpdateActivityURL+fmt.Sprintf("/%d", activityID), body)
+	// The dashboard shows all-time Eddington numbers even if the line is off
+	err = refreshRideHistory(userID, accessToken, time.Now())
 	if err != nil {
 		Logger.Println(err)
-		return
 	}
-	req.Header.Set("Authorization", "Bearer "+accessToken)
-	req.Header.Set("Content-Type", "application/json")
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	if settings.Eddington {
+		eddington, err := getEddington(userID, activityID)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["Eddington"] = eddington
+		}
+	}
+	if settings.Maintenance {
+		maintenance, err := getMaintenanceDue(userID, accessToken, activityGearID)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["Maintenance"] = maintenance
+		}
+	}
+	if settings.Teams {
+		teams, err := getAthleteTeamsProgress(userID)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["Teams"] = teams
+		}
+	}
+	if settings.Challenges {
+		challenges, err := getAthleteChallenges(userID, true)
+		if err != nil {
+			Logger.Println(err)
+		} else {
+			extra["Challenges"] = challenges
+		}
+	}
+
+	newDesc, err := renderDescription(goal, totalDistance, contributedDistance, activityDescription, signature, extra)
 	if err != nil {
-		Logger.Println(err)
-		return
+		return err
 	}
-	defer resp.Body.Close()
-	Logger.Printf("updating activity %d: %s\n", activityID, resp.Status)
+
+	// Strava sends update events for activities in the outbox, events are
+	// sent only when the description is rendered for the first time
+	firstRender := true
+	if settings.Preview {
+		previous, err := GetOutboxEntry(userID, activityID)
+		if err != nil {
+			return err
+		}
+		firstRender = previous == nil
+		Logger.Printf("activity %d is stored in the outbox\n", activityID)
+		err = SetOutboxEntry(userID, &OutboxEntry{
+			ActivityID:  activityID,
+			Name:        processedActivity.Name,
+			StartDate:   processedActivity.StartDate,
+			Original:    activityDescription,
+			Description: newDesc,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	} else {
+		err = annotateActivity(userID, accessToken, activityID, activityDescription, newDesc)
+		if err != nil {
+			return err
+		}
+	}
+	if reverted {
+		err = DeleteActivityReverted(userID, activityID)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Webhook retries would stall the annotation queue otherwise
+	if firstRender && !force {
+		go sendActivityEvents(userID, processedActivity, goal, totalDistance, contributedDistance)
+	}
+	return nil
+}
+
+// stravaAuthorizeURL returns the URL which asks the athlete to connect the
+// application. Strava passes `state` back to the register handler
+func stravaAuthorizeURL(state string) string {
+	params := url.Values{}
+	params.Add("client_id", rootAppID)
+	params.Add("response_type", "code")
+	params.Add("redirect_uri", "https://"+rootDomain+"/register")
+	params.Add("approval_prompt", "auto")
+	params.Add("scope", "read,activity:read_all,activity:write")
+	if state != "" {
+		params.Add("state", state)
+	}
+	return StravaAuthorizeURL + "?" + params.Encode()
 }
 
 // Returns all cycling activities of the current year
 func getYearActivities(accessToken string) (*[]Activity, error) {
-	startOfYear := time.Date(time.Now().Year(), time.January, 1, 0, 0, 0, 0, time.UTC).Unix()
+	startOfYear := time.Date(time.Now().Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+	return getActivitiesBetween(accessToken, startOfYear, time.Time{})
+}
 
-	url := StravaListActivitiesURL + fmt.Sprintf("?after=%d", startOfYear)
+// Returns all cycling activities which started after `after` and before
+// `before`. Zero `before` means no upper limit
+func getActivitiesBetween(accessToken string, after time.Time, before time.Time) (*[]Activity, error) {
+	url := StravaListActivitiesURL + fmt.Sprintf("?after=%d", after.Unix())
+	if !before.IsZero() {
+		url += fmt.Sprintf("&before=%d", before.Unix())
+	}
 	page := 0
 
 	var activities []Activity
@@ -176,6 +371,74 @@ func getYearActivities(accessToken string) (*[]Activity, error) {
 	return &activities, nil
 }
 
+// getPreviousYearDistance returns distance in meters the athlete covered on
+// the bikes in the previous year until the same day and time as `now`. Empty
+// list of bikes matches all activities. Activities of the previous year are
+// fetched once and cached
+func getPreviousYearDistance(athleteID int, accessToken string, now time.Time, gearIDs []string) (float64, error) {
+	var activities []Activity
+	key := PreviousYearKey{AthleteID: athleteID, Year: now.Year() - 1}
+	item := PreviousYearCache.Get(key)
+	if item != nil {
+		activities = item.Value()
+	} else {
+		startOfYear := time.Date(now.Year()-1, time.January, 1, 0, 0, 0, 0, time.UTC)
+		endOfYear := time.Date(now.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+		fetched, err := getActivitiesBetween(accessToken, startOfYear, endOfYear)
+		if err != nil {
+			return 0, err
+		}
+		activities = *fetched
+		PreviousYearCache.Set(key, activities, ttlcache.DefaultTTL)
+
+		err = SaveActivities(athleteID, activities)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+	return distanceUntil(activities, now.AddDate(-1, 0, 0), gearIDs), nil
+}
+
+// distanceUntil returns distance in meters of the activities on the bikes
+// which started before `until`
+func distanceUntil(activities []Activity, until time.Time, gearIDs []string) float64 {
+	distance := 0.0
+	for _, activity := range activities {
+		if activity.StartDate.Before(until) && matchesGear(activity, gearIDs) {
+			distance += activity.Distance
+		}
+	}
+	return distance
+}
+
+// Returns bikes of the athlete
+func getAthleteBikes(accessToken string) ([]Gear, error) {
+	req, err := http.NewRequest("GET", StravaAthleteURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve athlete: %s", resp.Status)
+	}
+
+	var athlete struct {
+		Bikes []Gear `json:"bikes"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&athlete)
+	if err != nil {
+		return nil, err
+	}
+	return athlete.Bikes, nil
+}
+
 func makePaginatedRequest(url string, accessToken string, page int) (*[]Activity, error) {
 	req, err := http.NewRequest("GET", url+"&page="+fmt.Sprintf("%d", page), nil)
 	if err != nil {
@@ -190,8 +453,7 @@ func makePaginatedRequest(url string, accessToken string, page int) (*[]Activity
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		Logger.Printf("Failed to retrieve activities: %s\n", resp.Status)
-		return nil, err
+		return nil, fmt.Errorf("failed to retrieve activities: %s", resp.Status)
 	}
 
 	var activities []Activity
@@ -203,12 +465,95 @@ func makePaginatedRequest(url string, accessToken string, page int) (*[]Activity
 	return &activities, nil
 }
 
+// updateActivityDescription replaces description of the activity on Strava.
+// Returns the status code of the Strava response, 0 if there was no response
+func updateActivityDescription(accessToken string, activityID int, description string) (int, error) {
+	data := struct {
+		Description string `json:"description"`
+	}{
+		Description: description,
+	}
+	dataJson, err := json.Marshal(data)
+	if err != nil {
+		return 0, err
+	}
+	body := bytes.NewBuffer(dataJson)
+	req, err := http.NewRequest("PUT", StravaUpdateActivityURL+fmt.Sprintf("/%d", activityID), body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	Logger.Printf("updating activity %d: %s\n", activityID, resp.Status)
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("failed to update activity %d: %s", activityID, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// annotateActivity replaces description of the activity with the rendered one
+// and records the annotation. `original` is the description the activity had
+// before
+func annotateActivity(athleteID int, accessToken string, activityID int, original string, description string) error {
+	err := SetOriginalDescription(athleteID, activityID, original)
+	if err != nil {
+		return err
+	}
+	err = editActivityDescription(athleteID, accessToken, activityID, AuditAnnotate, original, description)
+	if err != nil {
+		return err
+	}
+	return SetActivityAnnotated(athleteID, activityID)
+}
+
+// fetchActivity fetches the activity with its description. Strava lists
+// activities without descriptions
+var fetchActivity = getActivity
+
+// getActivity fetches the activity with its current description from Strava
+func getActivity(accessToken string, activityID int) (*Activity, error) {
+	req, err := http.NewRequest("GET", StravaUpdateActivityURL+fmt.Sprintf("/%d", activityID), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to retrieve activity %d: %s", activityID, resp.Status)
+	}
+
+	activity := &Activity{}
+	err = json.NewDecoder(resp.Body).Decode(activity)
+	if err != nil {
+		return nil, err
+	}
+	return activity, nil
+}
+
 // renderDescription renders description of the activity from the template
 // Notes:
 //   - all distance is in meters
 //   - `totalDistance` already includes `activityDistance`
-func renderDescription(goal, totalDistance, activityDistance float64, description, signature string) (string, error) {
-	year := time.Now().Year()
+//   - `extra` contains optional template variables, enabled by the athlete
+func renderDescription(goal, totalDistance, activityDistance float64, description, signature string, extra map[string]interface{}) (string, error) {
+	return renderDescriptionAt(time.Now(), goal, totalDistance, activityDistance, description, signature, extra)
+}
+
+// renderDescriptionAt renders description of the activity as it would be at
+// `now`. The year and the days left are calculated from `now`
+func renderDescriptionAt(now time.Time, goal, totalDistance, activityDistance float64, description, signature string, extra map[string]interface{}) (string, error) {
+	year := now.Year()
 	tmplContent, err := TemplatesStorage.ReadFile("templates/description.txt")
 	if err != nil {
 		return "", err
@@ -219,6 +564,12 @@ func renderDescription(goal, totalDistance, activityDistance float64, descriptio
 			format := fmt.Sprintf("%%.%df", 2)
 			return fmt.Sprintf(format, f)
 		},
+		"toKm": func(meters float64) string {
+			return fmt.Sprintf("%.2f", meters/1000)
+		},
+		"neg": func(f float64) float64 {
+			return -f
+		},
 		"greaterFloat": func(a float64, b float64) bool {
 			return a >= b
 		},
@@ -228,8 +579,7 @@ func renderDescription(goal, totalDistance, activityDistance float64, descriptio
 	tmpl := template.Must(template.New("example").Funcs(funcMap).Parse(string(tmplContent)))
 
 	// Render the template with the provided data
-	var buf bytes.Buffer
-	err = tmpl.Execute(&buf, map[string]interface{}{
+	data := map[string]interface{}{
 		"Description":   description,
 		"Year":          year,
 		"Goal":          goal / 1000,
@@ -237,9 +587,15 @@ func renderDescription(goal, totalDistance, activityDistance float64, descriptio
 		"Progress":      (totalDistance / goal) * 100,
 		"Contributed":   activityDistance / goal * 100,
 		"DistanceLeft":  (goal - totalDistance) / 1000,
-		"DaysLeft":      int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1),
+		"DaysLeft":      int(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC).Sub(now).Hours()/24 - 1),
 		"Signature":     signature,
-	})
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
 	if err != nil {
 		return "", err
 	}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 )
@@ -9,7 +10,7 @@ import (
 func Test_renderDescription_simple(t *testing.T) {
 	year := time.Now().Year()
 	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
-	desc, err := renderDescription(1000, 500, 10, "", "")
+	desc, err := renderDescription(1000, 500, 10, "", "", nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -29,7 +30,7 @@ func Test_renderDescription_simple(t *testing.T) {
 func Test_renderDescription_with_signature(t *testing.T) {
 	year := time.Now().Year()
 	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
-	desc, err := renderDescription(1000, 500, 10, "", "-- app")
+	desc, err := renderDescription(1000, 500, 10, "", "-- app", nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -50,7 +51,7 @@ func Test_renderDescription_with_signature(t *testing.T) {
 func Test_renderDescription_with_description(t *testing.T) {
 	year := time.Now().Year()
 	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
-	desc, err := renderDescription(1000, 500, 10, "other app", "-- app")
+	desc, err := renderDescription(1000, 500, 10, "other app", "-- app", nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -72,7 +73,7 @@ func Test_renderDescription_with_description(t *testing.T) {
 func Test_renderDescription_over(t *testing.T) {
 	year := time.Now().Year()
 	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
-	desc, err := renderDescription(1000000, 1100000, 150000, "", "")
+	desc, err := renderDescription(1000000, 1100000, 150000, "", "", nil)
 	if err != nil {
 		t.Error(err)
 		return
@@ -88,3 +89,122 @@ func Test_renderDescription_over(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func Test_renderDescription_year_over_year(t *testing.T) {
+	year := time.Now().Year()
+	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
+	desc, err := renderDescription(1000, 500, 10, "", "-- app", map[string]interface{}{
+		"LastYear":           year - 1,
+		"LastYearDifference": 312.0,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := fmt.Sprintf(`+1.00%% towards the goal!
+0.50 of 1.00 km (50.00%%) in %d
+0.50 km and %d days remains
++312.00 km vs. this day in %d
+-- app`, year, daysLeft, year-1)
+
+	if desc != expected {
+		fmt.Printf("Expected text: %q\n", expected)
+		fmt.Printf("  Actual text: %q\n", desc)
+		t.Fail()
+	}
+}
+
+func Test_renderDescription_year_over_year_behind(t *testing.T) {
+	year := time.Now().Year()
+	daysLeft := int(time.Until(time.Date(year+1, time.January, 1, 0, 0, 0, 0, time.UTC)).Hours()/24 - 1)
+	desc, err := renderDescription(1000, 500, 10, "", "", map[string]interface{}{
+		"LastYear":           year - 1,
+		"LastYearDifference": -12.5,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := fmt.Sprintf(`+1.00%% towards the goal!
+0.50 of 1.00 km (50.00%%) in %d
+0.50 km and %d days remains
+-12.50 km vs. this day in %d`, year, daysLeft, year-1)
+
+	if desc != expected {
+		fmt.Printf("Expected text: %q\n", expected)
+		fmt.Printf("  Actual text: %q\n", desc)
+		t.Fail()
+	}
+}
+
+func Test_renderDescription_streaks(t *testing.T) {
+	year := time.Now().Year()
+	line := fmt.Sprintf("🔥 5 day streak, 3 rides this week, 40 active days in %d", year)
+	for _, tc := range []struct {
+		extra    map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"Streaks": &Streaks{CurrentDaily: 5, RidesThisWeek: 3, ActiveDays: 40}}, true},
+		{nil, false},
+	} {
+		desc, err := renderDescription(1000, 500, 10, "", "-- app", tc.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(desc, line+"\n-- app") != tc.expected {
+			t.Errorf("expected the streak line to be shown: %v, got %q", tc.expected, desc)
+		}
+	}
+}
+
+func Test_renderDescription_teams(t *testing.T) {
+	line := "👥 Club: 120.50 of 1000.00 km (12.05%)"
+	team := &TeamProgress{Team: &Team{Name: "Club", Goal: 1000000}, Distance: 120500}
+	for _, tc := range []struct {
+		extra    map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"Teams": []*TeamProgress{team}}, true},
+		{map[string]interface{}{"Teams": []*TeamProgress{}}, false},
+		{nil, false},
+	} {
+		desc, err := renderDescription(1000, 500, 10, "", "-- app", tc.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(desc, line+"\n-- app") != tc.expected {
+			t.Errorf("expected the team line to be shown: %v, got %q", tc.expected, desc)
+		}
+	}
+}
+
+func Test_renderDescription_challenges(t *testing.T) {
+	line := "🏁 Spring: 120.00 km of 500.00 km, 2 of 5"
+	challenge := &Challenge{Name: "Spring", Metric: "distance", Target: 500000}
+	for _, tc := range []struct {
+		extra    map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"Challenges": []*ChallengeStatus{{
+			Challenge:    challenge,
+			Standing:     ChallengeStanding{Rank: 2, AthleteID: 1, Value: 120000},
+			Participants: 5,
+		}}}, true},
+		// The athlete has no standing yet
+		{map[string]interface{}{"Challenges": []*ChallengeStatus{{Challenge: challenge, Participants: 5}}}, false},
+		{nil, false},
+	} {
+		desc, err := renderDescription(1000, 500, 10, "", "-- app", tc.extra)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(desc, line+"\n-- app") != tc.expected {
+			t.Errorf("expected the challenge line to be shown: %v, got %q", tc.expected, desc)
+		}
+		if !tc.expected && strings.Contains(desc, "🏁") {
+			t.Errorf("expected no challenge line, got %q", desc)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// SubscriptionRefreshInterval is the minimal time between two lookups of the
+// push subscription on Strava
+var SubscriptionRefreshInterval = time.Minute
+
+// SubscriptionValidationDelay is the time after the callback validation when
+// the new subscription is looked up. Strava creates the subscription only
+// after the application responds to the validation
+var SubscriptionValidationDelay = 10 * time.Second
+
+// PushSubscription is the subscription of the application to Strava events.
+// Strava allows only one subscription per application
+type PushSubscription struct {
+	ID            int       `json:"id"`
+	ApplicationID int       `json:"application_id"`
+	CallbackURL   string    `json:"callback_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// stravaError returns the error with the status and the body of the failed
+// Strava response
+func stravaError(action string, resp *http.Response) error {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("failed to %s: %s %s", action, resp.Status, body)
+}
+
+// createPushSubscription subscribes the application to Strava events. Strava
+// validates the callback URL before it responds, so the application has to be
+// running. Returns ID of the subscription
+func createPushSubscription(callbackURL string, verifyToken string) (int, error) {
+	data := url.Values{}
+	data.Add("client_id", rootAppID)
+	data.Add("client_secret", rootAppSecret)
+	data.Add("callback_url", callbackURL)
+	data.Add("verify_token", verifyToken)
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.PostForm(StravaWebhookSubscribeURL, data)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return 0, stravaError("create the subscription", resp)
+	}
+	subscription := &PushSubscription{}
+	err = json.NewDecoder(resp.Body).Decode(subscription)
+	if err != nil {
+		return 0, err
+	}
+	return subscription.ID, nil
+}
+
+// getPushSubscription returns the subscription of the application. Returns
+// nil if there is none
+func getPushSubscription() (*PushSubscription, error) {
+	query := url.Values{}
+	query.Add("client_id", rootAppID)
+	query.Add("client_secret", rootAppSecret)
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(StravaWebhookSubscribeURL + "?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, stravaError("view the subscription", resp)
+	}
+	subscriptions := []PushSubscription{}
+	err = json.NewDecoder(resp.Body).Decode(&subscriptions)
+	if err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, nil
+	}
+	return &subscriptions[0], nil
+}
+
+// deletePushSubscription unsubscribes the application from Strava events
+func deletePushSubscription(subscriptionID int) error {
+	query := url.Values{}
+	query.Add("client_id", rootAppID)
+	query.Add("client_secret", rootAppSecret)
+	req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/%d?%s", StravaWebhookSubscribeURL, subscriptionID, query.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return stravaError("delete the subscription", resp)
+	}
+	return nil
+}
+
+// fetchPushSubscription looks up the subscription on Strava
+var fetchPushSubscription = getPushSubscription
+
+// subscription caches ID of the push subscription for incoming events
+var subscription struct {
+	mu        sync.Mutex
+	id        int
+	checkedAt time.Time
+}
+
+// loadSubscriptionID reads the stored subscription ID into the cache
+func loadSubscriptionID() error {
+	subscriptionID, err := GetSubscriptionID()
+	if err != nil {
+		return err
+	}
+	subscription.mu.Lock()
+	defer subscription.mu.Unlock()
+	subscription.id = subscriptionID
+	return nil
+}
+
+// refreshSubscription looks the subscription up on Strava and stores its ID.
+// Runs in the background at startup and when an event comes from an unknown
+// subscription, so webhook requests are answered without waiting for Strava
+func refreshSubscription() {
+	current, err := fetchPushSubscription()
+	if err != nil {
+		Logger.Printf("failed to look up the subscription: %s\n", err)
+		return
+	}
+	if current == nil {
+		Logger.Println("the application has no push subscription")
+		return
+	}
+	subscription.mu.Lock()
+	defer subscription.mu.Unlock()
+	if current.ID == subscription.id {
+		return
+	}
+	subscription.id = current.ID
+	err = SetSubscriptionID(current.ID)
+	if err != nil {
+		Logger.Println(err)
+	}
+}
+
+// checkSubscription reports whether the event came from the subscription of
+// the application
+// Notes:
+//   - unknown IDs start a background lookup on Strava at most once per
+//     `SubscriptionRefreshInterval`, so subscriptions created while the
+//     application is running are picked up and stored
+//   - events are accepted until the subscription is known, so the first
+//     events after the subscription is created aren't lost
+//   - events rejected before the lookup finishes or while Strava is
+//     unavailable are caught up by the reconciliation
+func checkSubscription(subscriptionID int, now time.Time) bool {
+	subscription.mu.Lock()
+	defer subscription.mu.Unlock()
+	if subscriptionID != 0 && subscriptionID == subscription.id {
+		return true
+	}
+	if now.Sub(subscription.checkedAt) >= SubscriptionRefreshInterval {
+		subscription.checkedAt = now
+		go refreshSubscription()
+	}
+	return subscriptionID != 0 && subscription.id == 0
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSubscription replaces the Strava lookup of the subscription and resets
+// the cached ID. Returns the pointer to the number of lookups
+func fakeSubscription(t *testing.T, current *PushSubscription, err error) *int {
+	lookups := 0
+	fetchPushSubscription = func() (*PushSubscription, error) {
+		lookups++
+		return current, err
+	}
+	subscription.id = 0
+	subscription.checkedAt = time.Time{}
+	t.Cleanup(func() {
+		fetchPushSubscription = getPushSubscription
+		subscription.id = 0
+		subscription.checkedAt = time.Time{}
+	})
+	return &lookups
+}
+
+func Test_refreshSubscription(t *testing.T) {
+	setupTestDB(t)
+	lookups := fakeSubscription(t, &PushSubscription{ID: 42}, nil)
+
+	refreshSubscription()
+	stored, err := GetSubscriptionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != 42 {
+		t.Errorf("expected subscription 42 to be stored, got %d", stored)
+	}
+	if !checkSubscription(42, time.Now()) || *lookups != 1 {
+		t.Errorf("expected the looked up subscription to be accepted without another lookup, got %d lookups", *lookups)
+	}
+}
+
+func Test_checkSubscription(t *testing.T) {
+	setupTestDB(t)
+	fakeSubscription(t, nil, nil)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	defer close(release)
+	fetchPushSubscription = func() (*PushSubscription, error) {
+		started <- struct{}{}
+		<-release
+		return nil, fmt.Errorf("strava is down")
+	}
+	waitLookup := func() {
+		select {
+		case <-started:
+		case <-time.After(time.Second):
+			t.Fatal("expected the subscription to be looked up in the background")
+		}
+	}
+	now := time.Date(2023, time.October, 18, 12, 0, 0, 0, time.UTC)
+
+	// the lookup blocks until released, so the check must not wait for it.
+	// Events are accepted until the subscription is known
+	if !checkSubscription(42, now) {
+		t.Errorf("expected the event to be accepted before the subscription is known")
+	}
+	waitLookup()
+	subscription.id = 7
+	if checkSubscription(42, now.Add(time.Second)) {
+		t.Errorf("expected unknown subscription to be rejected")
+	}
+	if checkSubscription(42, now.Add(SubscriptionRefreshInterval)) {
+		t.Errorf("expected unknown subscription to be rejected")
+	}
+	waitLookup()
+	if len(started) != 0 {
+		t.Errorf("expected one lookup per refresh interval")
+	}
+}
+
+func Test_checkSubscription_stored(t *testing.T) {
+	setupTestDB(t)
+	lookups := fakeSubscription(t, nil, fmt.Errorf("strava is down"))
+	err := SetSubscriptionID(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = loadSubscriptionID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !checkSubscription(42, time.Now()) || *lookups != 0 {
+		t.Errorf("expected the stored subscription to be accepted without lookups")
+	}
+	// no background lookups after the test
+	subscription.checkedAt = time.Now()
+	if checkSubscription(0, time.Now()) {
+		t.Errorf("expected events without subscription to be rejected")
+	}
+}
+
+func Test_webhook_unknown_subscription(t *testing.T) {
+	setupTestDB(t)
+	fakeSubscription(t, &PushSubscription{ID: 42}, nil)
+	refreshSubscription()
+	// no background lookups after the test
+	subscription.checkedAt = time.Now()
+	var events []int
+	previous := handleActivityEvent
+	handleActivityEvent = func(activityID int, athleteID int) {
+		events = append(events, activityID)
+	}
+	t.Cleanup(func() { handleActivityEvent = previous })
+
+	for _, tc := range []struct {
+		subscriptionID int
+		status         int
+		queued         int
+	}{
+		{7, http.StatusForbidden, 0},
+		{42, http.StatusOK, 1},
+	} {
+		body := fmt.Sprintf(`{"object_type":"activity","object_id":10,"aspect_type":"create","owner_id":1,"subscription_id":%d}`, tc.subscriptionID)
+		w := httptest.NewRecorder()
+		webhook(w, httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(body)))
+		if w.Code != tc.status {
+			t.Errorf("subscription %d: expected status %d, got %d", tc.subscriptionID, tc.status, w.Code)
+		}
+		if len(events) != tc.queued {
+			t.Errorf("subscription %d: expected %d processed activities, got %d", tc.subscriptionID, tc.queued, len(events))
+		}
+		events = nil
+	}
+}
+
+func Test_webhook_validation(t *testing.T) {
+	setupTestDB(t)
+	fakeSubscription(t, &PushSubscription{ID: 42}, nil)
+	token, delay := rootAppVerifyToken, SubscriptionValidationDelay
+	rootAppVerifyToken, SubscriptionValidationDelay = "secret", 0
+	t.Cleanup(func() { rootAppVerifyToken, SubscriptionValidationDelay = token, delay })
+
+	w := httptest.NewRecorder()
+	webhook(w, httptest.NewRequest(http.MethodGet, "/webhook?hub.mode=subscribe&hub.verify_token=secret&hub.challenge=abc", nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"hub.challenge":"abc"`) {
+		t.Fatalf("unexpected response %d %s", w.Code, w.Body.String())
+	}
+
+	// The new subscription is looked up in the background
+	deadline := time.Now().Add(time.Second)
+	for {
+		stored, err := GetSubscriptionID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored == 42 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected subscription 42 to be stored, got %d", stored)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
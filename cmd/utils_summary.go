@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// YearRecap is the year-end summary of the athlete. It is calculated once
+// the year is over and stored, so it doesn't change afterwards
+type YearRecap struct {
+	YearSummary
+	// BestMonth is the month with the longest distance, 0 if there were no rides
+	BestMonth        time.Month
+	PreviousDistance float64
+}
+
+// BestMonthDistance returns the distance of the best month in meters
+func (r *YearRecap) BestMonthDistance() float64 {
+	if r.BestMonth == 0 {
+		return 0
+	}
+	return r.Months[r.BestMonth-1]
+}
+
+// Change returns difference with the previous year's distance in meters
+func (r *YearRecap) Change() float64 {
+	return r.Distance - r.PreviousDistance
+}
+
+// buildYearRecap creates year-end summary for the year from the yearly summaries
+func buildYearRecap(summaries []*YearSummary, year int) *YearRecap {
+	recap := &YearRecap{YearSummary: YearSummary{Year: year}}
+	for _, summary := range summaries {
+		switch summary.Year {
+		case year:
+			recap.YearSummary = *summary
+		case year - 1:
+			recap.PreviousDistance = summary.Distance
+		}
+	}
+	for month, distance := range recap.Months {
+		if distance > 0 && distance > recap.BestMonthDistance() {
+			recap.BestMonth = time.Month(month + 1)
+		}
+	}
+	return recap
+}
+
+// renderYearRecap renders the text block of the year-end summary which can be
+// shared anywhere
+func renderYearRecap(recap *YearRecap) (string, error) {
+	tmplContent, err := TemplatesStorage.ReadFile("templates/summary.txt")
+	if err != nil {
+		return "", err
+	}
+
+	funcMap := template.FuncMap{
+		"toKm": func(meters float64) string {
+			return fmt.Sprintf("%.2f", meters/1000)
+		},
+		"toSignedKm": func(meters float64) string {
+			return fmt.Sprintf("%+.2f", meters/1000)
+		},
+		"toFixedTwo": func(f float64) string {
+			return fmt.Sprintf("%.2f", f)
+		},
+	}
+
+	tmpl, err := template.New("summary").Funcs(funcMap).Parse(string(tmplContent))
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, recap)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// yearEndSummaryJob creates summaries of the previous year for all athletes
+// who don't have one yet
+func yearEndSummaryJob() {
+	year := time.Now().Year() - 1
+	athleteIDs, err := GetAthleteIDs()
+	if err != nil {
+		Logger.Println(err)
+		return
+	}
+	for _, athleteID := range athleteIDs {
+		existing, err := GetYearRecap(athleteID, year)
+		if err != nil {
+			Logger.Println(err)
+			continue
+		}
+		if existing != nil {
+			continue
+		}
+
+		summaries, err := getYearSummaries(athleteID)
+		if err != nil {
+			Logger.Println(err)
+			continue
+		}
+		recap := buildYearRecap(summaries, year)
+		if recap.Rides == 0 {
+			continue
+		}
+		err = SaveYearRecap(athleteID, recap)
+		if err != nil {
+			Logger.Println(err)
+			continue
+		}
+		Logger.Printf("created %d summary for athlete %d\n", year, athleteID)
+	}
+}
+
+// summaryHandler renders the shareable year-end summary page of the athlete.
+// The page is available only if the athlete has the public profile
+func summaryHandler(w http.ResponseWriter, r *http.Request, athleteID int, year int) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	settings, err := GetProfileSettings(athleteID)
+	if err != nil || !settings.Public {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	recap, err := GetYearRecap(athleteID, year)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if recap == nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	if !settings.ShowGoal {
+		recap.Goal = 0
+	}
+	if !settings.ShowLongestRide {
+		recap.LongestRide = Activity{}
+	}
+	if !settings.ShowMonthly {
+		recap.BestMonth = 0
+		recap.Months = [12]float64{}
+	}
+
+	text, err := renderYearRecap(recap)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	renderHTML(w, "templates/summary.html", map[string]interface{}{
+		"Name":  GetAthleteName(athleteID),
+		"Recap": recap,
+		"Text":  text,
+	})
+}
@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_summaryHandler_settings(t *testing.T) {
+	setupTestDB(t)
+	recap := &YearRecap{
+		YearSummary: YearSummary{Year: 2022, Distance: 150000, Rides: 2, Goal: 1000000},
+		BestMonth:   time.May,
+	}
+	recap.Months[time.May-1] = 100000
+	recap.LongestRide = Activity{ID: 10, Name: "Long one", Distance: 100000}
+	err := SaveYearRecap(1, recap)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		settings ProfileSettings
+		shown    []string
+		hidden   []string
+	}{
+		{
+			ProfileSettings{Public: true, ShowGoal: true, ShowMonthly: true, ShowLongestRide: true},
+			[]string{"Best month: May", "Longest ride: Long one", "km goal"},
+			nil,
+		},
+		{
+			ProfileSettings{Public: true},
+			[]string{"150.00 km in 2 rides"},
+			[]string{"Best month", "Longest ride", "km goal"},
+		},
+	} {
+		err = SetProfileSettings(1, &tc.settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		profileHandler(w, httptest.NewRequest(http.MethodGet, "/athlete/1/summary/2022", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+		for _, expected := range tc.shown {
+			if !strings.Contains(w.Body.String(), expected) {
+				t.Errorf("%+v: expected %q on the page", tc.settings, expected)
+			}
+		}
+		for _, unexpected := range tc.hidden {
+			if strings.Contains(w.Body.String(), unexpected) {
+				t.Errorf("%+v: expected %q to be hidden", tc.settings, unexpected)
+			}
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Team is a group of athletes with a shared goal
+// Notes:
+//   - `Goal` is in meters
+//   - activities of the members in the `Year` count towards the goal
+type Team struct {
+	ID         string  `json:"id"`
+	Name       string  `json:"name"`
+	Year       int     `json:"year"`
+	Goal       float64 `json:"goal"`
+	OwnerID    int     `json:"owner_id"`
+	Members    []int   `json:"members"`
+	InviteCode string  `json:"invite_code"`
+}
+
+// TeamProgress is the progress of the team towards the goal
+// Notes:
+//   - `HiddenMembers` is the number of members who are not listed on the
+//     public team page, because their profile is private
+type TeamProgress struct {
+	*Team
+	Distance      float64
+	Members       []MemberProgress
+	HiddenMembers int
+}
+
+// MemberProgress is the contribution of the member to the team goal
+type MemberProgress struct {
+	AthleteID int
+	Name      string
+	Distance  float64
+}
+
+// Progress returns progress towards the goal in percents
+func (p *TeamProgress) Progress() float64 {
+	if p.Goal == 0 {
+		return 0
+	}
+	return p.Distance / p.Goal * 100
+}
+
+// getTeamProgress aggregates stored activities of all team members
+func getTeamProgress(team *Team) (*TeamProgress, error) {
+	progress := &TeamProgress{Team: team}
+	for _, athleteID := range team.Members {
+		activities, err := GetActivities(athleteID)
+		if err != nil {
+			return nil, err
+		}
+		member := MemberProgress{AthleteID: athleteID, Name: GetAthleteName(athleteID)}
+		for _, activity := range activities {
+			if activity.StartDateLocal.Year() == team.Year {
+				member.Distance += activity.Distance
+			}
+		}
+		progress.Distance += member.Distance
+		progress.Members = append(progress.Members, member)
+	}
+	sort.Slice(progress.Members, func(i, j int) bool {
+		return progress.Members[i].Distance > progress.Members[j].Distance
+	})
+	return progress, nil
+}
+
+// getAthleteTeamsProgress returns progress of all teams of the athlete
+func getAthleteTeamsProgress(athleteID int) ([]*TeamProgress, error) {
+	teams, err := GetTeams()
+	if err != nil {
+		return nil, err
+	}
+	result := []*TeamProgress{}
+	for _, team := range teams {
+		if !slices.Contains(team.Members, athleteID) {
+			continue
+		}
+		progress, err := getTeamProgress(team)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, progress)
+	}
+	return result, nil
+}
+
+// updateTeams creates or leaves the team based on the submitted account form
+func updateTeams(athleteID int, r *http.Request) error {
+	switch r.FormValue("action") {
+	case "createTeam":
+		goal, err := strconv.Atoi(r.FormValue("goal"))
+		if err != nil {
+			return err
+		}
+		id, err := GenerateRandomID(6)
+		if err != nil {
+			return err
+		}
+		inviteCode, err := GenerateRandomID(12)
+		if err != nil {
+			return err
+		}
+		return SaveTeam(&Team{
+			ID:         id,
+			Name:       strings.TrimSpace(r.FormValue("name")),
+			Year:       time.Now().Year(),
+			Goal:       float64(goal) * 1000,
+			OwnerID:    athleteID,
+			Members:    []int{athleteID},
+			InviteCode: inviteCode,
+		})
+	case "leaveTeam":
+		return UpdateTeam(r.FormValue("id"), func(team *Team) error {
+			members := []int{}
+			for _, member := range team.Members {
+				if member != athleteID {
+					members = append(members, member)
+				}
+			}
+			team.Members = members
+			return nil
+		})
+	}
+	return nil
+}
+
+// joinTeamHandler adds the athlete to the team using the invite link. Athletes
+// who are not logged in are sent to Strava first
+func joinTeamHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	code := r.URL.Query().Get("code")
+	team, err := GetTeamByInviteCode(code)
+	if err != nil {
+		http.Error(w, "Team not found", http.StatusNotFound)
+		return
+	}
+
+	athleteID, ok := loggedInAthlete(w, r)
+	if !ok {
+		return
+	}
+
+	joined := false
+	err = UpdateTeam(team.ID, func(team *Team) error {
+		if !slices.Contains(team.Members, athleteID) {
+			team.Members = append(team.Members, athleteID)
+			joined = true
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if joined {
+		logger.Printf("athlete %d joined team %s\n", athleteID, team.ID)
+	}
+	http.Redirect(w, r, "https://"+rootDomain+"/team/"+team.ID, http.StatusFound)
+}
+
+// teamHandler renders the progress page of the team. Only members with the
+// public profile are listed, the team distance includes everyone
+func teamHandler(w http.ResponseWriter, r *http.Request) {
+	logger, ok := r.Context().Value(HL).(*log.Logger)
+	if !ok {
+		logger = Logger
+	}
+
+	team, err := GetTeam(strings.TrimPrefix(r.URL.Path, "/team/"))
+	if err != nil {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	progress, err := getTeamProgress(team)
+	if err != nil {
+		logger.Println(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	members := []MemberProgress{}
+	for _, member := range progress.Members {
+		settings, err := GetProfileSettings(member.AthleteID)
+		if err != nil || !settings.Public {
+			progress.HiddenMembers++
+			continue
+		}
+		members = append(members, member)
+	}
+	progress.Members = members
+
+	renderHTML(w, "templates/team.html", progress)
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_getTeamProgress(t *testing.T) {
+	setupTestDB(t)
+	err := SaveActivities(1, []Activity{
+		{ID: 10, Distance: 30000, StartDateLocal: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 11, Distance: 50000, StartDateLocal: time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveActivities(2, []Activity{
+		{ID: 20, Distance: 70000, StartDateLocal: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	progress, err := getTeamProgress(&Team{Year: 2023, Goal: 200000, Members: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Distance != 100000 || progress.Progress() != 50 {
+		t.Errorf("expected 100000 m and 50%%, got %f m and %f%%", progress.Distance, progress.Progress())
+	}
+	if len(progress.Members) != 2 || progress.Members[0].AthleteID != 2 || progress.Members[1].Distance != 30000 {
+		t.Errorf("expected members sorted by distance, got %+v", progress.Members)
+	}
+}
+
+func Test_UpdateTeam_concurrent(t *testing.T) {
+	setupTestDB(t)
+	err := SaveTeam(&Team{ID: "t", Members: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for athleteID := 2; athleteID <= 11; athleteID++ {
+		wg.Add(1)
+		go func(athleteID int) {
+			defer wg.Done()
+			err := UpdateTeam("t", func(team *Team) error {
+				team.Members = append(team.Members, athleteID)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}(athleteID)
+	}
+	wg.Wait()
+
+	team, err := GetTeam("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(team.Members) != 11 {
+		t.Errorf("expected 11 members, got %v", team.Members)
+	}
+}
+
+func Test_updateTeams_leave(t *testing.T) {
+	setupTestDB(t)
+	err := SaveTeam(&Team{ID: "t", Members: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leave := func(athleteID int) {
+		form := url.Values{"action": {"leaveTeam"}, "id": {"t"}}
+		r := httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		err := updateTeams(athleteID, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	leave(1)
+	team, err := GetTeam("t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(team.Members) != 1 || team.Members[0] != 2 {
+		t.Errorf("expected only athlete 2 to remain, got %v", team.Members)
+	}
+	leave(2)
+	_, err = GetTeam("t")
+	if err == nil {
+		t.Errorf("expected the team without members to be deleted")
+	}
+}
+
+func Test_teamHandler_private_members(t *testing.T) {
+	setupTestDB(t)
+	for athleteID, name := range map[int]string{1: "Jane", 2: "John"} {
+		err := SaveAuthData(athleteID, &StravaResponseRefresh{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = SetAthleteName(athleteID, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := SetProfileSettings(1, &ProfileSettings{Public: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveTeam(&Team{ID: "t", Name: "Riders", Year: 2023, Goal: 100000, Members: []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	teamHandler(w, httptest.NewRequest(http.MethodGet, "/team/t", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Jane") || strings.Contains(body, "John") || !strings.Contains(body, "and 1 more") {
+		t.Errorf("expected only the public member to be listed:\n%s", body)
+	}
+}
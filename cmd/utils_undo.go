@@ -0,0 +1,237 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stripDescriptionBlock removes the rendered block from the description.
+// Used for activities whose original description is unknown
+// Notes:
+//   - the block starts with the progress line and ends with the signature.
+//     Text the athlete added after the signature is kept
+func stripDescriptionBlock(description string) (string, error) {
+	lines := strings.Split(description, "\n")
+	end := -1
+	for i := len(lines) - 1; i >= 0; i-- {
+		if strings.TrimSpace(lines[i]) == Signature {
+			end = i
+			break
+		}
+	}
+	start := -1
+	for i := end - 1; i >= 0; i-- {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "+") && strings.HasSuffix(line, "% towards the goal!") ||
+			strings.HasPrefix(line, "🏆") && strings.HasSuffix(line, "% of the goal!") {
+			start = i
+			break
+		}
+	}
+	if end == -1 || start == -1 {
+		return "", fmt.Errorf("description doesn't contain the block")
+	}
+	kept := append(lines[:start:start], lines[end+1:]...)
+	return strings.TrimSpace(strings.Join(kept, "\n")), nil
+}
+
+// undoActivity restores the description the activity had before the
+// annotation. Returns false if there was nothing to remove
+// Notes:
+//   - the activity is never annotated again
+//   - descriptions waiting in the outbox are discarded without Strava requests
+//   - activities annotated before annotations were recorded are fetched from
+//     Strava and the block is removed if the description has the signature
+func undoActivity(athleteID int, accessToken string, activityID int) (bool, error) {
+	reverted, err := IsActivityReverted(athleteID, activityID)
+	if err != nil || reverted {
+		return false, err
+	}
+	pending, err := GetOutboxEntry(athleteID, activityID)
+	if err != nil {
+		return false, err
+	}
+	if pending != nil {
+		err = DeleteOutboxEntry(athleteID, activityID)
+		if err != nil {
+			return false, err
+		}
+	}
+	annotated, err := IsActivityAnnotated(athleteID, activityID)
+	if err != nil {
+		return false, err
+	}
+	if !annotated && pending != nil {
+		return true, SetActivityReverted(athleteID, activityID)
+	}
+
+	activity, err := fetchActivity(accessToken, activityID)
+	if err != nil {
+		return false, err
+	}
+	if !strings.Contains(activity.Description, Signature) {
+		return false, SetActivityReverted(athleteID, activityID)
+	}
+	description, found, err := GetOriginalDescription(athleteID, activityID)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		description, err = stripDescriptionBlock(activity.Description)
+		if err != nil {
+			return false, fmt.Errorf("activity %d: %s", activityID, err)
+		}
+	}
+	err = editActivityDescription(athleteID, accessToken, activityID, AuditUndo, activity.Description, description)
+	if err != nil {
+		return false, err
+	}
+	return true, SetActivityReverted(athleteID, activityID)
+}
+
+// undoTargets returns IDs of activities with the signature, annotated
+// activities and activities waiting in the outbox. Returns activities of all
+// years if `year` is 0
+// Notes:
+//   - `activities` are activities of the year listed on Strava. Their start
+//     date decides the year, because only activities of the current year are
+//     stored
+//   - Strava lists activities without descriptions, so listed activities
+//     which are neither annotated, reverted nor in the outbox are fetched one
+//     by one to find the ones annotated before annotations were recorded
+//   - annotated activities which are not on Strava anymore are returned only
+//     for all years
+func undoTargets(athleteID int, accessToken string, year int, activities []Activity) ([]int, error) {
+	annotated, err := GetAnnotatedActivities(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	outbox, err := GetOutbox(athleteID)
+	if err != nil {
+		return nil, err
+	}
+	years := map[int]int{}
+	known := map[int]bool{}
+	for _, activityID := range annotated {
+		known[activityID] = true
+	}
+	for _, entry := range outbox {
+		years[entry.ActivityID] = entry.StartDate.Year()
+		known[entry.ActivityID] = true
+	}
+
+	candidates := []int{}
+	for _, activity := range activities {
+		years[activity.ID] = activity.StartDateLocal.Year()
+		if known[activity.ID] || year != 0 && years[activity.ID] != year {
+			continue
+		}
+		reverted, err := IsActivityReverted(athleteID, activity.ID)
+		if err != nil {
+			return nil, err
+		}
+		if reverted {
+			continue
+		}
+		// Activities annotated before annotations were recorded
+		detailed, err := fetchActivity(accessToken, activity.ID)
+		if err != nil {
+			return nil, err
+		}
+		if strings.Contains(detailed.Description, Signature) {
+			candidates = append(candidates, activity.ID)
+		}
+	}
+	candidates = append(candidates, annotated...)
+	for _, entry := range outbox {
+		candidates = append(candidates, entry.ActivityID)
+	}
+
+	seen := map[int]bool{}
+	targets := []int{}
+	for _, activityID := range candidates {
+		if seen[activityID] || year != 0 && years[activityID] != year {
+			continue
+		}
+		seen[activityID] = true
+		targets = append(targets, activityID)
+	}
+	return targets, nil
+}
+
+// undoAnnotations removes the block from activities of the year, or from all
+// activities if `year` is 0. Returns the number of restored activities
+// Notes:
+//   - it stops on the first error, e.g. because of the Strava rate limit.
+//     Restored activities are skipped when it is started again
+func undoAnnotations(athleteID int, year int) (int, error) {
+	accessToken, err := RefreshAccessToken(athleteID)
+	if err != nil {
+		return 0, err
+	}
+	after, before := time.Unix(0, 0), time.Time{}
+	if year != 0 {
+		after = time.Date(year, time.January, 1, 0, 0, 0, 0, time.UTC)
+		before = after.AddDate(1, 0, 0)
+	}
+	activities, err := getActivitiesBetween(accessToken, after, before)
+	if err != nil {
+		return 0, err
+	}
+	targets, err := undoTargets(athleteID, accessToken, year, *activities)
+	if err != nil {
+		return 0, err
+	}
+	restored := 0
+	for _, activityID := range targets {
+		changed, err := undoActivity(athleteID, accessToken, activityID)
+		if err != nil {
+			return restored, err
+		}
+		if changed {
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// updateUndo removes the block from the activities selected in the submitted
+// account form. Years and all activities are processed in the background
+// because of Strava rate limits
+func updateUndo(athleteID int, r *http.Request) error {
+	switch r.FormValue("action") {
+	case "undoActivity":
+		activityID, err := strconv.Atoi(r.FormValue("activityId"))
+		if err != nil {
+			return err
+		}
+		accessToken, err := RefreshAccessToken(athleteID)
+		if err != nil {
+			return err
+		}
+		_, err = undoActivity(athleteID, accessToken, activityID)
+		return err
+	case "undoYear", "undoAll":
+		year := 0
+		if r.FormValue("action") == "undoYear" {
+			var err error
+			year, err = strconv.Atoi(r.FormValue("year"))
+			if err != nil {
+				return err
+			}
+		}
+		go func() {
+			restored, err := undoAnnotations(athleteID, year)
+			if err != nil {
+				Logger.Printf("failed to undo annotations of athlete %d: %s\n", athleteID, err)
+			}
+			Logger.Printf("restored %d activities of athlete %d\n", restored, athleteID)
+		}()
+		return nil
+	default:
+		return fmt.Errorf("unknown action %s", r.FormValue("action"))
+	}
+}
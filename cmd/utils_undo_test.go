@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_stripDescriptionBlock(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		original string
+		total    float64
+		suffix   string
+	}{
+		{"empty", "", 500, ""},
+		{"with description", "Morning ride\nwith friends", 500, ""},
+		{"goal achieved", "Morning ride", 1500, ""},
+		{"text after the block", "Morning ride", 500, "\nadded later"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rendered, err := renderDescription(1000, tc.total, 10, tc.original, Signature, map[string]interface{}{"LastYear": 2022, "LastYearDifference": 1.5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			stripped, err := stripDescriptionBlock(rendered + tc.suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := strings.TrimSpace(tc.original + tc.suffix)
+			if stripped != expected {
+				t.Errorf("expected %q, got %q", expected, stripped)
+			}
+		})
+	}
+
+	_, err := stripDescriptionBlock("Morning ride")
+	if err == nil {
+		t.Errorf("expected an error for the description without the block")
+	}
+}
+
+func Test_SetOriginalDescription_keeps_first(t *testing.T) {
+	setupTestDB(t)
+	for _, description := range []string{"original", "rendered"} {
+		err := SetOriginalDescription(1, 10, description)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	description, found, err := GetOriginalDescription(1, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found || description != "original" {
+		t.Errorf("expected the first description, got %q", description)
+	}
+	_, found, _ = GetOriginalDescription(1, 11)
+	if found {
+		t.Errorf("expected no description for the unknown activity")
+	}
+}
+
+func Test_undoTargets(t *testing.T) {
+	setupTestDB(t)
+	// Activities of past years are not stored. Strava lists activities
+	// without descriptions
+	strava := []Activity{
+		{ID: 10, StartDateLocal: time.Date(2022, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 11, StartDateLocal: time.Date(2023, time.May, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 13, StartDateLocal: time.Date(2023, time.July, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: 14, StartDateLocal: time.Date(2023, time.July, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 16, StartDateLocal: time.Date(2023, time.July, 3, 0, 0, 0, 0, time.UTC)},
+	}
+	detailed := map[int]string{
+		// Annotated before annotations were recorded
+		13: "Commute\n" + Signature,
+		14: "Commute",
+		16: "Undone\n" + Signature,
+	}
+	fetched := []int{}
+	fetchActivity = func(accessToken string, activityID int) (*Activity, error) {
+		fetched = append(fetched, activityID)
+		return &Activity{ID: activityID, Description: detailed[activityID]}, nil
+	}
+	t.Cleanup(func() { fetchActivity = getActivity })
+	err := SetActivityReverted(1, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []int{10, 11, 15} {
+		err := SetActivityAnnotated(1, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = SetOutboxEntry(1, &OutboxEntry{ActivityID: 12, StartDate: time.Date(2023, time.June, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	targets, err := undoTargets(1, "", 2023, strava[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []int{13, 11, 12}) {
+		t.Errorf("unexpected targets of 2023 %v", targets)
+	}
+	// Annotated and reverted activities are not fetched
+	if !reflect.DeepEqual(fetched, []int{13, 14}) {
+		t.Errorf("expected only activities 13 and 14 to be fetched, got %v", fetched)
+	}
+	targets, err = undoTargets(1, "", 2022, strava[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(targets, []int{10}) {
+		t.Errorf("unexpected targets of 2022 %v", targets)
+	}
+	// Activity 15 was deleted on Strava
+	targets, err = undoTargets(1, "", 0, strava)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(targets) != 5 {
+		t.Errorf("expected 5 targets, got %v", targets)
+	}
+}
+
+func Test_undoActivity_outbox(t *testing.T) {
+	setupTestDB(t)
+	err := SetOutboxEntry(1, &OutboxEntry{ActivityID: 12})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pending descriptions don't need Strava requests
+	restored, err := undoActivity(1, "", 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !restored {
+		t.Errorf("expected the pending description to be discarded")
+	}
+	entry, _ := GetOutboxEntry(1, 12)
+	reverted, _ := IsActivityReverted(1, 12)
+	if entry != nil || !reverted {
+		t.Errorf("expected the activity to be reverted and removed from the outbox")
+	}
+
+	restored, err = undoActivity(1, "", 12)
+	if err != nil || restored {
+		t.Errorf("expected reverted activity to be skipped, got %v %v", restored, err)
+	}
+}
@@ -0,0 +1,407 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Supported webhook events
+const (
+	EventActivityProcessed = "activity.processed"
+	EventMilestoneCrossed  = "milestone.crossed"
+	EventGoalAchieved      = "goal.achieved"
+	EventPing              = "ping"
+)
+
+// WebhookEvents contains events athletes can subscribe to
+var WebhookEvents = []string{EventActivityProcessed, EventMilestoneCrossed, EventGoalAchieved}
+
+// MilestoneStep is the distance between milestones in meters
+const MilestoneStep = 1000000
+
+// WebhookRetries is the number of attempts to deliver an event
+var WebhookRetries = 3
+
+// WebhookBackoff is the delay before the second attempt. It doubles after
+// every failed attempt
+var WebhookBackoff = 5 * time.Second
+
+// WebhookTestTimeout limits the test delivery, which the athlete waits for
+// on the account page. It is attempted only once
+var WebhookTestTimeout = 5 * time.Second
+
+// WebhookDeliveryLogSize is the number of deliveries kept per athlete
+const WebhookDeliveryLogSize = 20
+
+// WebhookClient is the HTTP client used to deliver events. It connects only
+// to public addresses
+var WebhookClient = newPublicClient(10 * time.Second)
+
+// errPrivateAddress is returned when the endpoint is not on the public
+// internet
+var errPrivateAddress = errors.New("private addresses are not allowed")
+
+// privateNetworks contains ranges which are not covered by the checks of
+// net.IP, such as shared address space used by cloud providers
+var privateNetworks = []*net.IPNet{
+	{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)},
+	{IP: net.IPv4(192, 0, 0, 0), Mask: net.CIDRMask(24, 32)},
+	{IP: net.IPv4(198, 18, 0, 0), Mask: net.CIDRMask(15, 32)},
+}
+
+// isPublicIP reports whether the address is reachable on the public internet.
+// Loopback, private (including fly.io 6PN fdaa::/16), link-local (including
+// cloud metadata 169.254.169.254) and unspecified addresses are not
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, network := range privateNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPublicHost rejects endpoints which point to a private address
+// literally. Host names are checked when the connection is made
+func checkPublicHost(endpoint *url.URL) error {
+	host := endpoint.Hostname()
+	if strings.EqualFold(host, "localhost") || strings.HasSuffix(strings.ToLower(host), ".localhost") {
+		return errPrivateAddress
+	}
+	ip := net.ParseIP(host)
+	if ip != nil && !isPublicIP(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// newPublicClient returns the HTTP client which refuses to connect to private
+// addresses. The address is checked after the host name is resolved, so DNS
+// names pointing to internal hosts and redirects to them are rejected too
+func newPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublicIP(ip) {
+				return fmt.Errorf("%w: %s", errPrivateAddress, host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
+
+// Webhook is an HTTPS endpoint of the athlete which receives events
+// Notes:
+//   - payloads are signed with HMAC-SHA256 using `Secret`, the signature is
+//     sent in the `X-Go-Cycle-Signature` header as `sha256=<hex>`
+//   - empty `Events` means all events
+type Webhook struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Accepts reports whether the webhook is subscribed to the event
+func (h *Webhook) Accepts(event string) bool {
+	return event == EventPing || len(h.Events) == 0 || slices.Contains(h.Events, event)
+}
+
+// WebhookEvent is the JSON payload sent to webhooks
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Event     string      `json:"event"`
+	AthleteID int         `json:"athlete_id"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// WebhookDelivery is an entry of the delivery log
+type WebhookDelivery struct {
+	EventID    string    `json:"event_id"`
+	WebhookID  string    `json:"webhook_id"`
+	URL        string    `json:"url"`
+	Event      string    `json:"event"`
+	StatusCode int       `json:"status_code"`
+	Attempts   int       `json:"attempts"`
+	Error      string    `json:"error"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Succeeded reports whether the endpoint accepted the event
+func (d *WebhookDelivery) Succeeded() bool {
+	return d.Error == "" && d.StatusCode >= 200 && d.StatusCode < 300
+}
+
+// ActivityEventData is the data of `activity.processed` events
+type ActivityEventData struct {
+	Activity      Activity `json:"activity"`
+	Goal          float64  `json:"goal"`
+	TotalDistance float64  `json:"total_distance"`
+}
+
+// MilestoneEventData is the data of `milestone.crossed` and `goal.achieved`
+// events. All distance is in meters
+type MilestoneEventData struct {
+	ActivityID    int     `json:"activity_id"`
+	Milestone     float64 `json:"milestone"`
+	TotalDistance float64 `json:"total_distance"`
+}
+
+// signWebhookPayload returns the signature of the payload sent in the
+// `X-Go-Cycle-Signature` header
+func signWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliverWebhook posts the event to the webhook. Network errors, 5xx and 429
+// responses are retried with an exponential backoff until `attempts` are made
+// or `ctx` is done
+func deliverWebhook(ctx context.Context, webhook *Webhook, event *WebhookEvent, attempts int) *WebhookDelivery {
+	delivery := &WebhookDelivery{
+		EventID:   event.ID,
+		WebhookID: webhook.ID,
+		URL:       webhook.URL,
+		Event:     event.Event,
+		CreatedAt: time.Now().UTC(),
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		delivery.Error = err.Error()
+		return delivery
+	}
+
+	backoff := WebhookBackoff
+	for delivery.Attempts < attempts && ctx.Err() == nil {
+		if delivery.Attempts > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		delivery.Attempts++
+		delivery.StatusCode = 0
+		delivery.Error = ""
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(payload))
+		if err != nil {
+			delivery.Error = err.Error()
+			return delivery
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "go-cycle-webhooks")
+		req.Header.Set("X-Go-Cycle-Event", event.Event)
+		req.Header.Set("X-Go-Cycle-Delivery", event.ID)
+		req.Header.Set("X-Go-Cycle-Signature", signWebhookPayload(webhook.Secret, payload))
+		resp, err := WebhookClient.Do(req)
+		if err != nil {
+			delivery.Error = err.Error()
+			continue
+		}
+		resp.Body.Close()
+		delivery.StatusCode = resp.StatusCode
+		if resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+	}
+	return delivery
+}
+
+// sendWebhookEvent delivers the event to all webhooks of the athlete which
+// are subscribed to it and records deliveries in the log
+func sendWebhookEvent(athleteID int, event string, data interface{}) error {
+	webhooks, err := GetWebhooks(athleteID)
+	if err != nil {
+		return err
+	}
+	id, err := GenerateRandomID(12)
+	if err != nil {
+		return err
+	}
+	payload := &WebhookEvent{
+		ID:        id,
+		Event:     event,
+		AthleteID: athleteID,
+		CreatedAt: time.Now().UTC(),
+		Data:      data,
+	}
+	for i := range webhooks {
+		if !webhooks[i].Accepts(event) {
+			continue
+		}
+		delivery := deliverWebhook(context.Background(), &webhooks[i], payload, WebhookRetries)
+		if !delivery.Succeeded() {
+			Logger.Printf("webhook %s of athlete %d failed: %d %s\n", webhooks[i].ID, athleteID, delivery.StatusCode, delivery.Error)
+		}
+		err = AddWebhookDelivery(athleteID, delivery)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// crossedMilestones returns milestones reached by going from `before` to
+// `after` meters
+func crossedMilestones(before float64, after float64) []float64 {
+	milestones := []float64{}
+	for m := (math.Floor(before/MilestoneStep) + 1) * MilestoneStep; m <= after; m += MilestoneStep {
+		milestones = append(milestones, m)
+	}
+	return milestones
+}
+
+// sendActivityEvents notifies the athlete about the processed activity and
+// milestones and the goal it crossed via webhooks and notifiers
+// Notes:
+//   - `totalDistance` already includes `contributedDistance` of the activity
+func sendActivityEvents(athleteID int, activity Activity, goal float64, totalDistance float64, contributedDistance float64) {
+	err := sendWebhookEvent(athleteID, EventActivityProcessed, ActivityEventData{
+		Activity:      activity,
+		Goal:          goal,
+		TotalDistance: totalDistance,
+	})
+	if err != nil {
+		Logger.Println(err)
+	}
+
+	before := totalDistance - contributedDistance
+	for _, milestone := range crossedMilestones(before, totalDistance) {
+		data := MilestoneEventData{
+			ActivityID:    activity.ID,
+			Milestone:     milestone,
+			TotalDistance: totalDistance,
+		}
+		err = sendWebhookEvent(athleteID, EventMilestoneCrossed, data)
+		if err != nil {
+			Logger.Println(err)
+		}
+		err = sendMilestoneNotification(athleteID, EventMilestoneCrossed, data)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+	if goal > 0 && before < goal && totalDistance >= goal {
+		data := MilestoneEventData{
+			ActivityID:    activity.ID,
+			Milestone:     goal,
+			TotalDistance: totalDistance,
+		}
+		err = sendWebhookEvent(athleteID, EventGoalAchieved, data)
+		if err != nil {
+			Logger.Println(err)
+		}
+		err = sendMilestoneNotification(athleteID, EventGoalAchieved, data)
+		if err != nil {
+			Logger.Println(err)
+		}
+	}
+}
+
+// updateWebhooks adds, removes or tests the webhook based on the submitted
+// account form
+func updateWebhooks(athleteID int, r *http.Request) error {
+	webhooks, err := GetWebhooks(athleteID)
+	if err != nil {
+		return err
+	}
+
+	switch r.FormValue("action") {
+	case "addWebhook":
+		endpoint, err := url.Parse(strings.TrimSpace(r.FormValue("url")))
+		if err != nil {
+			return err
+		}
+		if endpoint.Scheme != "https" || endpoint.Host == "" {
+			return fmt.Errorf("webhook URL must be an HTTPS URL")
+		}
+		err = checkPublicHost(endpoint)
+		if err != nil {
+			return err
+		}
+		for _, event := range r.Form["event"] {
+			if !slices.Contains(WebhookEvents, event) {
+				return fmt.Errorf("unknown event %s", event)
+			}
+		}
+		id, err := GenerateRandomID(6)
+		if err != nil {
+			return err
+		}
+		secret, err := GenerateRandomID(24)
+		if err != nil {
+			return err
+		}
+		webhooks = append(webhooks, Webhook{
+			ID:        id,
+			URL:       endpoint.String(),
+			Secret:    secret,
+			Events:    r.Form["event"],
+			CreatedAt: time.Now().UTC(),
+		})
+	case "deleteWebhook":
+		kept := []Webhook{}
+		for _, webhook := range webhooks {
+			if webhook.ID != r.FormValue("id") {
+				kept = append(kept, webhook)
+			}
+		}
+		webhooks = kept
+	case "testWebhook":
+		for i := range webhooks {
+			if webhooks[i].ID != r.FormValue("id") {
+				continue
+			}
+			id, err := GenerateRandomID(12)
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), WebhookTestTimeout)
+			defer cancel()
+			delivery := deliverWebhook(ctx, &webhooks[i], &WebhookEvent{
+				ID:        id,
+				Event:     EventPing,
+				AthleteID: athleteID,
+				CreatedAt: time.Now().UTC(),
+				Data:      map[string]string{},
+			}, 1)
+			return AddWebhookDelivery(athleteID, delivery)
+		}
+		return fmt.Errorf("webhook %s doesn't exist", r.FormValue("id"))
+	}
+	return SetWebhooks(athleteID, webhooks)
+}
@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_crossedMilestones(t *testing.T) {
+	tests := []struct {
+		before, after float64
+		expected      []float64
+	}{
+		{0, 999999, []float64{}},
+		{950000, 1000000, []float64{1000000}},
+		{1000000, 1500000, []float64{}},
+		{1900000, 3100000, []float64{2000000, 3000000}},
+	}
+	for _, tt := range tests {
+		milestones := crossedMilestones(tt.before, tt.after)
+		if !reflect.DeepEqual(milestones, tt.expected) {
+			t.Errorf("crossedMilestones(%v, %v) = %v, expected %v", tt.before, tt.after, milestones, tt.expected)
+		}
+	}
+}
+
+// setWebhookClient replaces the client and the backoff of webhook deliveries
+// for the test
+func setWebhookClient(t *testing.T, client *http.Client) {
+	backoff, previous := WebhookBackoff, WebhookClient
+	WebhookBackoff = time.Millisecond
+	WebhookClient = client
+	t.Cleanup(func() {
+		WebhookBackoff = backoff
+		WebhookClient = previous
+	})
+}
+
+func Test_sendActivityEvents(t *testing.T) {
+	setupTestDB(t)
+
+	var received []WebhookEvent
+	var failures int32 = 1
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Go-Cycle-Signature") != signWebhookPayload("secret", body) {
+			t.Errorf("invalid signature %s", r.Header.Get("X-Go-Cycle-Signature"))
+		}
+		// The first delivery fails and has to be retried
+		if atomic.AddInt32(&failures, -1) >= 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event WebhookEvent
+		err := json.Unmarshal(body, &event)
+		if err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get("X-Go-Cycle-Event") != event.Event {
+			t.Errorf("event header %s doesn't match payload %s", r.Header.Get("X-Go-Cycle-Event"), event.Event)
+		}
+		received = append(received, event)
+	}))
+	defer server.Close()
+	setWebhookClient(t, server.Client())
+
+	err := SetWebhooks(1, []Webhook{
+		{ID: "all", URL: server.URL, Secret: "secret"},
+		{ID: "goal", URL: server.URL + "/goal", Secret: "secret", Events: []string{EventGoalAchieved}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sendActivityEvents(1, Activity{ID: 7, Distance: 60000}, 1000000, 1020000, 60000)
+
+	events := []string{}
+	for _, event := range received {
+		events = append(events, event.Event)
+	}
+	expected := []string{EventActivityProcessed, EventMilestoneCrossed, EventGoalAchieved, EventGoalAchieved}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected events %v, got %v", expected, events)
+	}
+
+	deliveries, err := GetWebhookDeliveries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 4 {
+		t.Fatalf("expected 4 deliveries, got %d", len(deliveries))
+	}
+	first := deliveries[len(deliveries)-1]
+	if first.Event != EventActivityProcessed || first.Attempts != 2 || !first.Succeeded() {
+		t.Errorf("expected the first delivery to succeed on retry, got %+v", first)
+	}
+}
+
+func Test_deliverWebhook_failed(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	setWebhookClient(t, server.Client())
+
+	delivery := deliverWebhook(context.Background(), &Webhook{URL: server.URL}, &WebhookEvent{Event: EventPing}, WebhookRetries)
+	if delivery.Succeeded() || delivery.Attempts != WebhookRetries || delivery.StatusCode != http.StatusInternalServerError {
+		t.Errorf("expected failed delivery after %d attempts, got %+v", WebhookRetries, delivery)
+	}
+}
+
+func Test_deliverWebhook_private_address(t *testing.T) {
+	requests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer server.Close()
+	setWebhookClient(t, newPublicClient(time.Second))
+
+	delivery := deliverWebhook(context.Background(), &Webhook{URL: server.URL}, &WebhookEvent{Event: EventPing}, WebhookRetries)
+	if delivery.Succeeded() || !strings.Contains(delivery.Error, errPrivateAddress.Error()) || requests != 0 {
+		t.Errorf("expected delivery to the loopback address to be refused, got %+v", delivery)
+	}
+}
+
+func Test_checkPublicHost(t *testing.T) {
+	for _, tc := range []struct {
+		url    string
+		public bool
+	}{
+		{"https://example.com/hook", true},
+		{"https://93.184.216.34/hook", true},
+		{"https://localhost/hook", false},
+		{"https://127.0.0.1:8080/hook", false},
+		{"https://10.0.0.1/hook", false},
+		{"https://192.168.1.1/hook", false},
+		{"https://169.254.169.254/latest/meta-data", false},
+		{"https://100.64.0.1/hook", false},
+		{"https://[::1]/hook", false},
+		{"https://[fdaa:0:1::3]/hook", false},
+		{"https://[::ffff:127.0.0.1]/hook", false},
+		{"https://0.0.0.0/hook", false},
+	} {
+		endpoint, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkPublicHost(endpoint)
+		if (err == nil) != tc.public {
+			t.Errorf("%s: expected public %t, got %v", tc.url, tc.public, err)
+		}
+	}
+}
+
+func Test_updateWebhooks_test_delivery(t *testing.T) {
+	setupTestDB(t)
+	var requests int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	setWebhookClient(t, server.Client())
+	err := SetWebhooks(1, []Webhook{{ID: "w1", URL: server.URL}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The athlete waits for the test delivery, so it isn't retried
+	form := url.Values{"action": {"testWebhook"}, "id": {"w1"}}
+	r := httptest.NewRequest(http.MethodPost, "/account", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	err = updateWebhooks(1, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deliveries, err := GetWebhookDeliveries(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&requests) != 1 || len(deliveries) != 1 || deliveries[0].Attempts != 1 {
+		t.Errorf("expected one attempt, got %d requests and %+v", requests, deliveries)
+	}
+}